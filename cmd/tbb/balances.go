@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"kryptcoin/database"
+	"os"
+)
+
+func getBalancesCmd() *cobra.Command {
+	balancesCmd := &cobra.Command{
+		Use:   "balances",
+		Short: "Interact with balances (list...).",
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	balancesCmd.AddCommand(balancesListCmd())
+	return balancesCmd
+}
+
+func balancesListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists all balances.",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			fmt.Printf("Accounts balances at %x:\n", state.LatestBlockHash())
+			fmt.Println("__________________")
+			fmt.Println("")
+			for account, balance := range state.Balances {
+				fmt.Printf("%s: %s\n", account.String(), balance)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(listCmd)
+	return listCmd
+}
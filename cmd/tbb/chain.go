@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"kryptcoin/database"
+	"kryptcoin/fs"
+	"os"
+)
+
+func chainCmd() *cobra.Command {
+	chainCmd := &cobra.Command{
+		Use:   "chain",
+		Short: "Exports and imports the chain (export, import...).",
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	chainCmd.AddCommand(chainExportCmd())
+	chainCmd.AddCommand(chainImportCmd())
+	return chainCmd
+}
+
+func chainExportCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Writes the genesis and all the blocks to a compressed chain archive.",
+		Long: fmt.Sprintf(
+			"Writes the genesis and all the blocks to a compressed chain archive. The '%s' block store can be exported while a node is running on the data dir, the '%s' block store only once the node is stopped.",
+			database.BlockStoreFile,
+			database.BlockStoreLevelDB,
+		),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, _ := cmd.Flags().GetString(flagFile)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			opts := database.DefaultOptions()
+			opts.BlockStore = blockStore
+
+			archive, err := os.Create(fs.ExpandPath(file))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			manifestHash, manifest, err := database.ExportChain(getDataDirFromCmd(cmd), opts, archive)
+			if err == nil {
+				err = archive.Close()
+			}
+			if err != nil {
+				archive.Close()
+				os.Remove(archive.Name())
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Exported %d block(s) up to height %d (%s) to %s\n", manifest.Blocks, manifest.TipHeight, manifest.TipHash.Hex(), archive.Name())
+			fmt.Printf("Manifest hash: %s\n", manifestHash.Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(exportCmd)
+	addChainFileFlag(exportCmd)
+	exportCmd.Flags().String(
+		flagBlockStore,
+		database.BlockStoreFile,
+		fmt.Sprintf("Block storage backend of the data dir, '%s' or '%s'.", database.BlockStoreFile, database.BlockStoreLevelDB),
+	)
+
+	return exportCmd
+}
+
+func chainImportCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Rebuilds the chain of a new data dir from a chain archive, validating every block.",
+		Run: func(cmd *cobra.Command, args []string) {
+			file, _ := cmd.Flags().GetString(flagFile)
+			manifestHash, _ := cmd.Flags().GetString(flagManifestHash)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			opts := database.DefaultOptions()
+			opts.BlockStore = blockStore
+			// The imported blocks are synced periodically and once more when the State is closed
+			opts.Fsync = database.FsyncInterval
+
+			archive, err := os.Open(fs.ExpandPath(file))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer archive.Close()
+
+			progress := func(height uint64, manifest database.ChainArchiveManifest) {
+				if height%chainImportProgressEvery == 0 || height == manifest.TipHeight {
+					fmt.Printf("Imported block %d/%d\n", height, manifest.TipHeight)
+				}
+			}
+
+			manifest, err := database.ImportChain(archive, getDataDirFromCmd(cmd), opts, manifestHash, progress)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Imported %d block(s) up to height %d (%s)\n", manifest.Blocks, manifest.TipHeight, manifest.TipHash.Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(importCmd)
+	addChainFileFlag(importCmd)
+	importCmd.Flags().String(flagManifestHash, "", "Expected manifest hash of the chain archive, as printed by the export.")
+	importCmd.Flags().String(
+		flagBlockStore,
+		database.BlockStoreFile,
+		fmt.Sprintf("Block storage backend of the new data dir, '%s' or '%s'.", database.BlockStoreFile, database.BlockStoreLevelDB),
+	)
+
+	return importCmd
+}
+
+const chainImportProgressEvery = 100
+
+func addChainFileFlag(cmd *cobra.Command) {
+	cmd.Flags().String(flagFile, "", "Path to the chain archive.")
+	cmd.MarkFlagRequired(flagFile)
+}
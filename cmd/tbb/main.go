@@ -14,6 +14,14 @@ const flagKeystoreFile = "keystore"
 const flagBootstrapAcct = "bootstrap_account"
 const flagBootstrapIp = "bootstrap_ip"
 const flagBootstrapPort = "bootstrap_port"
+const flagBlockStore = "block_store"
+const flagSnapshotInterval = "snapshot_interval"
+const flagFsync = "fsync"
+const flagPrune = "prune"
+const flagSegmentSize = "segment_size"
+const flagSeal = "seal"
+const flagFile = "file"
+const flagManifestHash = "manifest_hash"
 
 func main() {
 	tbbCmd := &cobra.Command{
@@ -27,6 +35,7 @@ func main() {
 	tbbCmd.AddCommand(getBalancesCmd())
 	tbbCmd.AddCommand(getRunCmd())
 	tbbCmd.AddCommand(walletCmd())
+	tbbCmd.AddCommand(chainCmd())
 
 	err := tbbCmd.Execute()
 	if err != nil {
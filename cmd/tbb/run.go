@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"kryptcoin/database"
+	"kryptcoin/node"
+	"kryptcoin/wallet"
+	"os"
+)
+
+func getRunCmd() *cobra.Command {
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Launches the berries blockchain node and its HTTP API.",
+		Run: func(cmd *cobra.Command, args []string) {
+			miner, _ := cmd.Flags().GetString(flagMiner)
+			ip, _ := cmd.Flags().GetString(flagIP)
+			port, _ := cmd.Flags().GetUint64(flagPort)
+			bootstrapIp, _ := cmd.Flags().GetString(flagBootstrapIp)
+			bootstrapPort, _ := cmd.Flags().GetUint64(flagBootstrapPort)
+			bootstrapAcct, _ := cmd.Flags().GetString(flagBootstrapAcct)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+			snapshotInterval, _ := cmd.Flags().GetUint64(flagSnapshotInterval)
+			fsync, _ := cmd.Flags().GetString(flagFsync)
+			prune, _ := cmd.Flags().GetUint64(flagPrune)
+			segmentSize, _ := cmd.Flags().GetInt64(flagSegmentSize)
+			seal, _ := cmd.Flags().GetBool(flagSeal)
+
+			fmt.Println("Launching the berries blockchain node and its HTTP API...")
+
+			bootstrap := node.NewPeerNode(
+				bootstrapIp,
+				bootstrapPort,
+				true,
+				database.NewAccount(bootstrapAcct),
+				false,
+			)
+
+			stateOpts := database.DefaultOptions()
+			stateOpts.BlockStore = blockStore
+			stateOpts.SnapshotInterval = snapshotInterval
+			stateOpts.Fsync = fsync
+			stateOpts.Prune = prune
+			stateOpts.SegmentSize = segmentSize << 20
+
+			n := node.NewNodeWithOptions(
+				getDataDirFromCmd(cmd),
+				ip,
+				port,
+				bootstrap,
+				database.NewAccount(miner),
+				stateOpts,
+			)
+			if seal {
+				password := getPassPhrase("Enter the password of the sealer account: ", false)
+				key, err := wallet.LoadKeystoreKey(database.NewAccount(miner), password, wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)))
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				n.SetSealerKey(key)
+			}
+
+			err := n.Run(context.Background())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(runCmd)
+	runCmd.Flags().String(flagMiner, node.DefaultMiner, "Miner account of this node to receive block rewards.")
+	runCmd.Flags().String(flagIP, node.DefaultIP, "Exposed IP for communication with peers.")
+	runCmd.Flags().Uint64(flagPort, node.DefaultHTTPPort, "Exposed HTTP port for communication with peers.")
+	runCmd.Flags().String(flagBootstrapIp, node.DefaultBootstrapIp, "Default bootstrap server to interconnect peers.")
+	runCmd.Flags().Uint64(flagBootstrapPort, node.DefaultHTTPPort, "Default bootstrap server port to interconnect peers.")
+	runCmd.Flags().String(flagBootstrapAcct, node.DefaultBootstrapAcc, "Default bootstrap genesis account.")
+	runCmd.Flags().String(
+		flagBlockStore,
+		database.BlockStoreFile,
+		fmt.Sprintf("Block storage backend, '%s' or '%s'.", database.BlockStoreFile, database.BlockStoreLevelDB),
+	)
+	runCmd.Flags().Uint64(flagSnapshotInterval, database.DefaultSnapshotInterval, "Save a State snapshot every N blocks, 0 disables snapshots.")
+	runCmd.Flags().String(
+		flagFsync,
+		database.FsyncAlways,
+		fmt.Sprintf("When new blocks are flushed to the disk, '%s', '%s' or '%s'.", database.FsyncAlways, database.FsyncInterval, database.FsyncNever),
+	)
+
+	runCmd.Flags().Uint64(flagPrune, 0, "Keep only the last N blocks and the blocks since the oldest State snapshot, 0 keeps the full history.")
+	runCmd.Flags().Int64(flagSegmentSize, database.DefaultSegmentSize>>20, "Size in MiB of the block segment files of the file store, pruned nodes also start a new segment every N blocks.")
+	runCmd.Flags().Bool(flagSeal, false, "Seal the blocks of a proof of authority chain with the miner account, its keystore password is prompted.")
+
+	return runCmd
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/console/prompt"
+	"github.com/spf13/cobra"
+	"kryptcoin/wallet"
+	"os"
+)
+
+func walletCmd() *cobra.Command {
+	walletCmd := &cobra.Command{
+		Use:   "wallet",
+		Short: "Manages blockchain accounts and keys.",
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	walletCmd.AddCommand(walletNewAccountCmd())
+	walletCmd.AddCommand(walletPrintPrivKeyCmd())
+	return walletCmd
+}
+
+func walletNewAccountCmd() *cobra.Command {
+	newAccountCmd := &cobra.Command{
+		Use:   "new-account",
+		Short: "Creates a new account with a new set of a elliptic-curve Private + Public keys.",
+		Run: func(cmd *cobra.Command, args []string) {
+			password := getPassPhrase("Enter a password: ", true)
+			dataDir := getDataDirFromCmd(cmd)
+
+			acc, err := wallet.NewKeystoreAccount(dataDir, password)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("New account created: %s\n", acc.Hex())
+			fmt.Printf("Saved to: %s\n", wallet.GetKeystoreDirPath(dataDir))
+		},
+	}
+
+	addDefaultRequiredFlags(newAccountCmd)
+	return newAccountCmd
+}
+
+func walletPrintPrivKeyCmd() *cobra.Command {
+	printPrivKeyCmd := &cobra.Command{
+		Use:   "pk-print",
+		Short: "Unlocks keystore file and prints the Private + Public keys.",
+		Run: func(cmd *cobra.Command, args []string) {
+			ksFile, _ := cmd.Flags().GetString(flagKeystoreFile)
+			password := getPassPhrase("Enter a password to decrypt the wallet: ", false)
+
+			keyJson, err := os.ReadFile(ksFile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			key, err := keystore.DecryptKey(keyJson, password)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			spew.Dump(key)
+		},
+	}
+
+	addKeystoreFlag(printPrivKeyCmd)
+	return printPrivKeyCmd
+}
+
+func getPassPhrase(text string, confirmation bool) string {
+	password, err := prompt.Stdin.PromptPassword(text)
+	if err != nil {
+		fmt.Printf("Failed to read password: %v\n", err)
+		os.Exit(1)
+	}
+
+	if confirmation {
+		confirm, err := prompt.Stdin.PromptPassword(text)
+		if err != nil {
+			fmt.Printf("Failed to read password confirmation: %v\n", err)
+			os.Exit(1)
+		}
+		if password != confirm {
+			fmt.Println("Passwords do not match")
+			os.Exit(1)
+		}
+	}
+	return password
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+	"io"
+)
+
+// Amount is an amount of tokens, a 256-bit unsigned integer. Its arithmetic is checked, an overflow
+// is an error instead of wrapping around.
+//
+// JSON encodes an amount as a decimal string, the JSON numbers of the blocks, snapshots and genesis files
+// written before are decoded too. RLP encodes it like the uint it replaced, the hashes of the blocks and
+// Txns and the state roots don't change.
+type Amount uint256.Int
+
+func NewAmount(value uint64) Amount {
+	return Amount(*uint256.NewInt(value))
+}
+
+// ParseAmount parses the decimal digits of an amount
+func ParseAmount(decimal string) (Amount, error) {
+	if decimal == "" {
+		return Amount{}, fmt.Errorf("invalid amount, empty")
+	}
+	for _, c := range decimal {
+		if c < '0' || c > '9' {
+			return Amount{}, fmt.Errorf("invalid amount %q, expected decimal digits", decimal)
+		}
+	}
+
+	var i uint256.Int
+	err := i.SetFromDecimal(decimal)
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid amount %q: %s", decimal, err)
+	}
+	return Amount(i), nil
+}
+
+func (a Amount) int() *uint256.Int {
+	i := uint256.Int(a)
+	return &i
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum, overflow := new(uint256.Int).AddOverflow(a.int(), b.int())
+	if overflow {
+		return Amount{}, fmt.Errorf("amount overflow, %s + %s doesn't fit in 256 bits", a, b)
+	}
+	return Amount(*sum), nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	diff, underflow := new(uint256.Int).SubOverflow(a.int(), b.int())
+	if underflow {
+		return Amount{}, fmt.Errorf("amount underflow, %s - %s is negative", a, b)
+	}
+	return Amount(*diff), nil
+}
+
+// MulUint multiplies the amount by a count, like the gas of a Txn
+func (a Amount) MulUint(n uint) (Amount, error) {
+	product, overflow := new(uint256.Int).MulOverflow(a.int(), uint256.NewInt(uint64(n)))
+	if overflow {
+		return Amount{}, fmt.Errorf("amount overflow, %s * %d doesn't fit in 256 bits", a, n)
+	}
+	return Amount(*product), nil
+}
+
+// Rsh returns the amount shifted right by n bits, halved n times
+func (a Amount) Rsh(n uint) Amount {
+	return Amount(*new(uint256.Int).Rsh(a.int(), n))
+}
+
+// Cmp returns -1, 0 or 1 when the amount is lower, equal or greater than b
+func (a Amount) Cmp(b Amount) int {
+	return a.int().Cmp(b.int())
+}
+
+func (a Amount) IsZero() bool {
+	return a.int().IsZero()
+}
+
+// String returns the decimal digits of the amount
+func (a Amount) String() string {
+	return a.int().Dec()
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + a.String() + `"`), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	// The amounts were JSON numbers before they became 256-bit wide
+	decimal := string(data)
+	if len(data) >= 2 && data[0] == '"' && data[len(data)-1] == '"' {
+		decimal = string(data[1 : len(data)-1])
+	}
+
+	parsed, err := ParseAmount(decimal)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a Amount) EncodeRLP(w io.Writer) error {
+	return a.int().EncodeRLP(w)
+}
+
+func (a *Amount) DecodeRLP(s *rlp.Stream) error {
+	return s.ReadUint256((*uint256.Int)(a))
+}
+
+// legacyAmount is an Amount in the frozen JSON layouts prior to OIP-2, a JSON number like the uint it replaced
+type legacyAmount Amount
+
+func (a legacyAmount) MarshalJSON() ([]byte, error) {
+	return []byte(Amount(a).String()), nil
+}
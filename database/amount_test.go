@@ -0,0 +1,103 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+	"strings"
+	"testing"
+)
+
+func TestAmountEncoding(t *testing.T) {
+	amount, err := ParseAmount("115792089237316195423570985008687907853269984665640564039457584007913129639935")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"115792089237316195423570985008687907853269984665640564039457584007913129639935"` {
+		t.Fatalf("expected the amount encoded as a decimal string, got %s", encoded)
+	}
+	var decoded Amount
+	if err = json.Unmarshal(encoded, &decoded); err != nil || decoded != amount {
+		t.Fatalf("expected the amount %s decoded, got %s: %v", amount, decoded, err)
+	}
+
+	// The genesis files, blocks and snapshots written before encode the amounts as JSON numbers
+	var balances map[common.Address]Amount
+	if err = json.Unmarshal([]byte(`{"0x0418A658C5874D2Fe181145B685d2e73D761865D": 1000000}`), &balances); err != nil {
+		t.Fatal(err)
+	}
+	if balances[NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")] != NewAmount(1_000_000) {
+		t.Fatalf("expected the legacy JSON number decoded, got %v", balances)
+	}
+
+	for _, invalid := range []string{`-1`, `"1.5"`, `"0x10"`, `""`, `1e3`} {
+		if err = json.Unmarshal([]byte(invalid), &decoded); err == nil {
+			t.Fatalf("the amount %s must be rejected", invalid)
+		}
+	}
+
+	// RLP encodes the amounts like the uint they replaced, the hashes don't change
+	for _, value := range []uint64{0, 1, 1000, 1 << 40} {
+		expected, err := rlp.EncodeToBytes(uint(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err = rlp.EncodeToBytes(NewAmount(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, expected) {
+			t.Fatalf("expected the RLP encoding %x of %d, got %x", expected, value, encoded)
+		}
+		if err = rlp.DecodeBytes(encoded, &decoded); err != nil || decoded != NewAmount(value) {
+			t.Fatalf("expected the amount %d decoded from RLP, got %s: %v", value, decoded, err)
+		}
+	}
+}
+
+func TestAmountOverflow(t *testing.T) {
+	maxAmount := Amount(*new(uint256.Int).SetAllOne())
+
+	if _, err := maxAmount.Add(NewAmount(1)); err == nil {
+		t.Fatal("an overflowing addition must fail")
+	}
+	if _, err := NewAmount(1).Sub(NewAmount(2)); err == nil {
+		t.Fatal("an underflowing subtraction must fail")
+	}
+	if _, err := maxAmount.MulUint(2); err == nil {
+		t.Fatal("an overflowing multiplication must fail")
+	}
+
+	// A Txn whose cost overflows must be rejected instead of costing almost nothing
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	state := newTestState(t, Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB"})
+	defer state.Close()
+
+	pending := state.Copy()
+	err := ApplyTxn(signTestTxn(t, newTestTxn(sender, miner, maxAmount, 1, ""), key), &pending)
+	if err == nil || !strings.Contains(err.Error(), "overflow") {
+		t.Fatalf("a Txn with an overflowing cost must be rejected, got error: %v", err)
+	}
+
+	// Nor can the genesis balances overflow the supply
+	content, err := json.Marshal(Genesis{Balances: map[common.Address]Amount{sender: maxAmount, miner: NewAmount(1)}, Symbol: "OPB", Forks: testForks(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	if err = InitDataDirIfNotExists(dataDir, content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewStateFromDisk(dataDir); err == nil || !strings.Contains(err.Error(), "overflow") {
+		t.Fatalf("overflowing genesis balances must be rejected, got error: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// A chain archive is a tar.gz of 3 files, in this order:
+//
+//	manifest.json  the ChainArchiveManifest, identified by its sha256 printed on export
+//	genesis.json   the genesis of the chain
+//	blocks.db      every block of the chain as framed records, from height 0
+const (
+	chainArchiveVersion = 1
+
+	chainArchiveManifestName = "manifest.json"
+	chainArchiveGenesisName  = "genesis.json"
+	chainArchiveBlocksName   = "blocks.db"
+)
+
+// ChainArchiveManifest describes the content of a chain archive so it can be verified on import
+type ChainArchiveManifest struct {
+	Version     int    `json:"version"`
+	GenesisHash Hash   `json:"genesis_sha256"`
+	BlocksHash  Hash   `json:"blocks_sha256"`
+	Blocks      uint64 `json:"blocks"`
+	TipHeight   uint64 `json:"tip_height"`
+	TipHash     Hash   `json:"tip_hash"`
+}
+
+// ExportChain writes the genesis and all the blocks of the data dir to a chain archive.
+// The blocks are read from the block store without modifying it. A node may be running on a data dir
+// with the file store, the leveldb store is locked by the node and must be exported once it's stopped.
+// The returned hash identifies the manifest of the archive.
+func ExportChain(dataDir string, opts Options, w io.Writer) (Hash, ChainArchiveManifest, error) {
+	genesis, err := os.ReadFile(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+
+	store, err := openReadOnlyBlockStore(dataDir, opts)
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	defer store.Close()
+
+	// The blocks are written to a temporary file first, their hash is part of the manifest
+	blocksFile, err := os.CreateTemp("", "tbb-export-*.db")
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	defer os.Remove(blocksFile.Name())
+	defer blocksFile.Close()
+
+	manifest := ChainArchiveManifest{Version: chainArchiveVersion, GenesisHash: sha256.Sum256(genesis)}
+	blocksHash := sha256.New()
+	blocksWriter := bufio.NewWriter(io.MultiWriter(blocksFile, blocksHash))
+
+	err = store.Iterate(0, math.MaxUint64, func(blockFs BlockFS) error {
+		if blockFs.Value.Header.Height != manifest.Blocks {
+			return fmt.Errorf("block at height %d is missing, a pruned chain can't be exported", manifest.Blocks)
+		}
+
+		record, err := encodeRecord(blockFs)
+		if err != nil {
+			return err
+		}
+		_, err = blocksWriter.Write(record)
+		if err != nil {
+			return err
+		}
+
+		manifest.Blocks++
+		manifest.TipHeight = blockFs.Value.Header.Height
+		manifest.TipHash = blockFs.Key
+		return nil
+	})
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	if manifest.Blocks == 0 {
+		return Hash{}, ChainArchiveManifest{}, fmt.Errorf("no blocks to export")
+	}
+
+	err = blocksWriter.Flush()
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	copy(manifest.BlocksHash[:], blocksHash.Sum(nil))
+
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	err = writeArchiveFile(archive, chainArchiveManifestName, int64(len(manifestJson)), bytes.NewReader(manifestJson))
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	err = writeArchiveFile(archive, chainArchiveGenesisName, int64(len(genesis)), bytes.NewReader(genesis))
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+
+	blocksSize, err := blocksFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	_, err = blocksFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	err = writeArchiveFile(archive, chainArchiveBlocksName, blocksSize, blocksFile)
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return Hash{}, ChainArchiveManifest{}, err
+	}
+	return sha256.Sum256(manifestJson), manifest, nil
+}
+
+// ImportChain rebuilds the chain of a chain archive into a data dir without a chain yet.
+// Every block goes through the full validation of State.AddBlock. When manifestHash
+// is not empty, the manifest of the archive must have this sha256 hex hash.
+// progress is called after each imported block.
+func ImportChain(r io.Reader, dataDir string, opts Options, manifestHash string, progress func(height uint64, manifest ChainArchiveManifest)) (ChainArchiveManifest, error) {
+	if fileExist(getGenesisJsonFilePath(dataDir)) {
+		return ChainArchiveManifest{}, fmt.Errorf("data dir %s already has a chain", dataDir)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	manifestJson, err := readArchiveFile(archive, chainArchiveManifestName)
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+	if actual := sha256.Sum256(manifestJson); manifestHash != "" && hex.EncodeToString(actual[:]) != manifestHash {
+		return ChainArchiveManifest{}, fmt.Errorf("manifest hash mismatch, expected %s got %x", manifestHash, actual)
+	}
+
+	var manifest ChainArchiveManifest
+	err = json.Unmarshal(manifestJson, &manifest)
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+	if manifest.Version != chainArchiveVersion {
+		return ChainArchiveManifest{}, fmt.Errorf("unsupported chain archive version %d", manifest.Version)
+	}
+
+	genesis, err := readArchiveFile(archive, chainArchiveGenesisName)
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+	if sha256.Sum256(genesis) != manifest.GenesisHash {
+		return ChainArchiveManifest{}, fmt.Errorf("genesis.json doesn't match the manifest")
+	}
+
+	header, err := archive.Next()
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+	if header.Name != chainArchiveBlocksName {
+		return ChainArchiveManifest{}, fmt.Errorf("expected %s in the chain archive, got %s", chainArchiveBlocksName, header.Name)
+	}
+
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		return ChainArchiveManifest{}, err
+	}
+
+	err = importBlocks(archive, dataDir, opts, manifest, progress)
+	if err != nil {
+		// Leave the data dir as it was, without a chain
+		_ = os.RemoveAll(getDatabaseDirPath(dataDir))
+		return ChainArchiveManifest{}, err
+	}
+	return manifest, nil
+}
+
+func importBlocks(r io.Reader, dataDir string, opts Options, manifest ChainArchiveManifest, progress func(uint64, ChainArchiveManifest)) error {
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	blocksHash := sha256.New()
+	reader := bufio.NewReader(io.TeeReader(r, blocksHash))
+
+	imported := uint64(0)
+	for {
+		blockFs, _, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read block %d of the chain archive: %s", imported, err)
+		}
+
+		hash, err := state.AddBlock(blockFs.Value)
+		if err != nil {
+			return fmt.Errorf("invalid block at height %d: %s", blockFs.Value.Header.Height, err)
+		}
+		if hash != blockFs.Key {
+			return fmt.Errorf("block at height %d has hash %s, not %s", blockFs.Value.Header.Height, hash.Hex(), blockFs.Key.Hex())
+		}
+
+		imported++
+		if progress != nil {
+			progress(blockFs.Value.Header.Height, manifest)
+		}
+	}
+
+	var actualHash Hash
+	copy(actualHash[:], blocksHash.Sum(nil))
+	if actualHash != manifest.BlocksHash || imported != manifest.Blocks || state.LatestBlockHash() != manifest.TipHash {
+		return fmt.Errorf("imported blocks don't match the manifest, %d of %d blocks imported", imported, manifest.Blocks)
+	}
+	return nil
+}
+
+func writeArchiveFile(archive *tar.Writer, name string, size int64, content io.Reader) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, content)
+	return err
+}
+
+func readArchiveFile(archive *tar.Reader, name string) ([]byte, error) {
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s from the chain archive: %s", name, err)
+	}
+	if header.Name != name {
+		return nil, fmt.Errorf("expected %s in the chain archive, got %s", name, header.Name)
+	}
+	return io.ReadAll(archive)
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestChainExportImport(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	dataDir := setupTestDataDir(t, map[common.Address]Amount{sender: NewAmount(1000)})
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := newTestTxn(sender, miner, NewAmount(10), 1, "")
+	_, err = state.AddBlock(mineTestBlock(t, state, miner, signTestTxn(t, txn, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.AddBlock(mineTestBlock(t, state, miner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBalances := state.Balances
+	expectedTip := state.LatestBlockHash()
+
+	// The chain is exported while the State is still open
+	var archive bytes.Buffer
+	manifestHash, manifest, err := ExportChain(dataDir, DefaultOptions(), &archive)
+	state.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Blocks != 2 || manifest.TipHash != expectedTip {
+		t.Fatalf("expected 2 blocks up to %s in the manifest, got %d up to %s", expectedTip.Hex(), manifest.Blocks, manifest.TipHash.Hex())
+	}
+
+	_, err = ImportChain(bytes.NewReader(archive.Bytes()), t.TempDir(), DefaultOptions(), Hash{}.Hex(), nil)
+	if err == nil {
+		t.Fatal("the import of an archive with another manifest hash should fail")
+	}
+
+	importDir := t.TempDir()
+	imported := 0
+	progress := func(height uint64, manifest ChainArchiveManifest) {
+		imported++
+	}
+	_, err = ImportChain(bytes.NewReader(archive.Bytes()), importDir, DefaultOptions(), manifestHash.Hex(), progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("expected a progress report for the 2 blocks, got %d", imported)
+	}
+
+	state, err = NewStateFromDisk(importDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.LatestBlockHash() != expectedTip {
+		t.Fatalf("expected the imported tip %s, got %s", expectedTip.Hex(), state.LatestBlockHash().Hex())
+	}
+	for account, balance := range expectedBalances {
+		if state.Balances[account] != balance {
+			t.Fatalf("expected the balance %d for %s, got %d", balance, account, state.Balances[account])
+		}
+	}
+	if _, err = state.GetMinedTxn(mustTxnHash(t, txn).Hex()); err != nil {
+		t.Fatalf("the imported Txn should be indexed: %s", err)
+	}
+
+	_, err = ImportChain(bytes.NewReader(archive.Bytes()), importDir, DefaultOptions(), "", nil)
+	if err == nil {
+		t.Fatal("the import into a data dir with a chain should fail")
+	}
+}
+
+func TestChainExportLevelDBRequiresStoppedNode(t *testing.T) {
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	dataDir := setupTestDataDir(t, map[common.Address]Amount{miner: NewAmount(1000)})
+	opts := DefaultOptions()
+	opts.BlockStore = BlockStoreLevelDB
+
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.AddBlock(mineTestBlock(t, state, miner))
+	if err != nil {
+		state.Close()
+		t.Fatal(err)
+	}
+
+	// The running node holds the lock of the leveldb store
+	var archive bytes.Buffer
+	_, _, err = ExportChain(dataDir, opts, &archive)
+	state.Close()
+	if err == nil || !strings.Contains(err.Error(), "stop the node") {
+		t.Fatalf("the export of a leveldb store locked by a running node should fail, got error: %v", err)
+	}
+
+	_, manifest, err := ExportChain(dataDir, opts, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Blocks != 1 {
+		t.Fatalf("expected 1 block in the manifest, got %d", manifest.Blocks)
+	}
+}
+
+func mustTxnHash(t *testing.T, txn Txn) Hash {
+	hash, err := txn.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"github.com/ethereum/go-ethereum/common"
-	"regexp"
 )
 
 const Reward = 100
@@ -38,6 +36,15 @@ type BlockHeader struct {
 	Time   uint64         `json:"time"`
 	Nonce  uint32         `json:"nonce"`
 	Miner  common.Address `json:"miner"`
+
+	Version    uint8  `json:"version"`
+	Difficulty uint64 `json:"difficulty"`
+	// TxRoot is the Merkle root of the block Txns, empty prior to OIP-5
+	TxRoot Hash `json:"tx_root"`
+	// StateRoot is the root of the account trie after the block was applied, empty prior to OIP-6
+	StateRoot Hash `json:"state_root"`
+	// Seal is the sealer signature of a proof of authority block, empty with proof of work
+	Seal []byte `json:"seal,omitempty"`
 }
 type Block struct {
 	Header BlockHeader `json:"header"`
@@ -50,31 +57,52 @@ type BlockFS struct {
 }
 
 func NewBlock(height uint64, parent Hash, time uint64, nonce uint32, miner common.Address, txns []SignedTxn) Block {
-	return Block{BlockHeader{height, parent, time, nonce, miner}, txns}
+	return Block{BlockHeader{height, parent, time, nonce, miner, VersionLegacyJSON, 0, Hash{}, Hash{}, nil}, txns}
 }
 
+// Hash returns the block hash. A header with a TxRoot commits to the Txns,
+// only the header is hashed then.
 func (b Block) Hash() (Hash, error) {
-	blockJson, err := json.Marshal(b)
+	if !b.Header.TxRoot.IsEmpty() {
+		return b.Header.Hash()
+	}
+
+	blockEncoded, err := encodeBlock(b)
 	if err != nil {
 		return Hash{}, err
 	}
-	return sha256.Sum256(blockJson), nil
+	return sha256.Sum256(blockEncoded), nil
 }
 
-func (b Block) GasReward() uint {
-	reward := uint(0)
-	for _, txn := range b.Txns {
-		reward += txn.GasCost()
+// Hash returns the hash of a header with a TxRoot, the hash of its block
+func (h BlockHeader) Hash() (Hash, error) {
+	headerEncoded, err := encodeBlockHeader(h)
+	if err != nil {
+		return Hash{}, err
 	}
-	return reward
+	return sha256.Sum256(headerEncoded), nil
 }
 
-// IsBlockHashValid Validates that the block hash starts with 2 leading zeros
-func IsBlockHashValid(hash Hash) bool {
-	hexHash := hash.Hex()
-	pattern := "^0*"
+func (b Block) GasReward() (Amount, error) {
+	reward := Amount{}
+	for _, txn := range b.Txns {
+		gasCost, err := txn.GasCost()
+		if err != nil {
+			return Amount{}, err
+		}
+		reward, err = reward.Add(gasCost)
+		if err != nil {
+			return Amount{}, err
+		}
+	}
+	return reward, nil
+}
 
-	re := regexp.MustCompile(pattern)
-	match := re.FindString(hexHash)
-	return len(match) == blockDifficulty
+// Fees returns the Txn fees credited to the miner of the block, the coinbase Txn pays none
+func (b Block) Fees(rules Rules) (Amount, error) {
+	_, txns := splitCoinbase(b.Txns, rules)
+	if rules.IsOIP1 {
+		return Block{Txns: txns}.GasReward()
+	}
+	return NewAmount(uint64(TxnFee)).MulUint(uint(len(txns)))
 }
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limits of the blocks of a genesis.json without block_limits, see OIP-8
+const (
+	DefaultBlockGasLimit = 1000 * TxnGas
+	DefaultMaxBlockSize  = 1 << 20
+)
+
+// rlpListSlack covers the list headers of an RLP block growing with its Txns
+const rlpListSlack = 16
+
+// BlockLimits caps the Txns of a block in genesis.json, 0 for no limit
+type BlockLimits struct {
+	// GasLimit caps the sum of the gas of the Txns of a block
+	GasLimit uint `json:"gas_limit"`
+	// MaxSize caps the length in bytes of the canonical encoding of a block
+	MaxSize int `json:"max_size"`
+}
+
+var defaultBlockLimits = BlockLimits{DefaultBlockGasLimit, DefaultMaxBlockSize}
+
+// BlockLimits returns the limits of the block at the height, no limits prior to OIP-8
+func (c ChainConfig) BlockLimits(height uint64) BlockLimits {
+	if !c.IsActive(OIP8, height) {
+		return BlockLimits{}
+	}
+	return c.limits
+}
+
+// BlockLimits returns the limits of the next block
+func (s *State) BlockLimits() BlockLimits {
+	return s.chainConfig.BlockLimits(s.NextBlockHeight())
+}
+
+// validateBlockLimits checks the block fits the limits of its height
+func (c ChainConfig) validateBlockLimits(b Block) error {
+	limits := c.BlockLimits(b.Header.Height)
+
+	if limits.GasLimit > 0 {
+		gas := uint(0)
+		for _, txn := range b.Txns {
+			gas += txn.Gas
+		}
+		if gas > limits.GasLimit {
+			return fmt.Errorf("block at height %d uses %d gas, more than the gas limit %d", b.Header.Height, gas, limits.GasLimit)
+		}
+	}
+
+	if limits.MaxSize > 0 {
+		encoded, err := encodeBlock(b)
+		if err != nil {
+			return err
+		}
+		if len(encoded) > limits.MaxSize {
+			return fmt.Errorf("block at height %d is %d bytes, more than the max block size %d", b.Header.Height, len(encoded), limits.MaxSize)
+		}
+	}
+	return nil
+}
+
+// blockSpace tracks the room left for Txns in the next block
+type blockSpace struct {
+	limits BlockLimits
+	gas    uint
+	size   int
+}
+
+func (s *State) newBlockSpace() (*blockSpace, error) {
+	size, err := maxEmptyBlockSize(s.EncodingVersion())
+	if err != nil {
+		return nil, err
+	}
+	// Since OIP-11 the coinbase Txn takes room in the block too
+	if s.Rules().IsOIP11 {
+		coinbaseSize, err := maxCoinbaseSize(s.EncodingVersion())
+		if err != nil {
+			return nil, err
+		}
+		size += coinbaseSize
+	}
+	return &blockSpace{s.BlockLimits(), 0, size}, nil
+}
+
+// fits tells if the block has room left for the Txn, and returns the bytes it takes
+func (b *blockSpace) fits(txn SignedTxn) (bool, int, error) {
+	encoded, err := encodeSignedTxn(txn)
+	if err != nil {
+		return false, 0, err
+	}
+	// The Txns of a block are separated by a comma prior to OIP-2
+	txnSize := len(encoded) + 1
+
+	if b.limits.GasLimit > 0 && b.gas+txn.Gas > b.limits.GasLimit {
+		return false, txnSize, nil
+	}
+	if b.limits.MaxSize > 0 && b.size+txnSize > b.limits.MaxSize {
+		return false, txnSize, nil
+	}
+	return true, txnSize, nil
+}
+
+func (b *blockSpace) add(txn SignedTxn, txnSize int) {
+	b.gas += txn.Gas
+	b.size += txnSize
+}
+
+// maxEmptyBlockSize returns the largest encoding of a block without Txns, with all its header fields at their longest
+func maxEmptyBlockSize(version uint8) (int, error) {
+	header := BlockHeader{math.MaxUint64, Hash{}, math.MaxUint64, math.MaxUint32, [20]byte{}, version, 0, Hash{}, Hash{}, nil}
+	if version == VersionRLP {
+		header.Difficulty = math.MaxUint64
+		header.TxRoot = Hash{1}
+		header.StateRoot = Hash{1}
+		header.Seal = make([]byte, 65)
+	}
+
+	encoded, err := encodeBlock(Block{header, []SignedTxn{}})
+	if err != nil {
+		return 0, err
+	}
+	return len(encoded) + rlpListSlack, nil
+}
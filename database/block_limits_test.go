@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestBlockLimits(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	txns := make([]SignedTxn, 3)
+	for i := range txns {
+		txn := newTestTxn(sender, miner, NewAmount(10), uint(i+1), strings.Repeat("x", 200))
+		txn.Time = uint64(1000 + i)
+		txns[i] = signTestTxn(t, txn, key)
+	}
+	// Handed out of order, like the pending Txns of a node
+	pending := []SignedTxn{txns[2], txns[0], txns[1]}
+
+	encoded, err := encodeSignedTxn(txns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptySize, err := maxEmptyBlockSize(VersionRLP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseSize, err := maxCoinbaseSize(VersionRLP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		limits BlockLimits
+	}{
+		{"gas limit", BlockLimits{GasLimit: 2 * TxnGas}},
+		{"max size", BlockLimits{MaxSize: emptySize + coinbaseSize + 2*(len(encoded)+1)}},
+	}
+	for _, tc := range tests {
+		genesis := Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", BlockLimits: &tc.limits}
+		state := newTestState(t, genesis)
+
+		fitted, err := state.SelectBlockTxns(pending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fitted) != 2 || fitted[0].Nonce != 1 || fitted[1].Nonce != 2 {
+			t.Fatalf("%s: expected the Txns with nonces 1 and 2 to fit in the block, got %v", tc.name, fitted)
+		}
+
+		_, err = state.AddBlock(mineTestBlock(t, state, miner, txns...))
+		if err == nil || !strings.Contains(err.Error(), "more than the") {
+			t.Fatalf("%s: a block beyond the limits must be rejected, got error: %v", tc.name, err)
+		}
+		_, err = state.AddBlock(mineTestBlock(t, state, miner, fitted...))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		state.Close()
+	}
+
+	// No limits prior to OIP-8
+	genesis := Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", Forks: testForks(map[string]uint64{OIP8: ForkDisabled}), BlockLimits: &BlockLimits{GasLimit: TxnGas}}
+	state := newTestState(t, genesis)
+	defer state.Close()
+
+	fitted, err := state.SelectBlockTxns(pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) != 3 {
+		t.Fatalf("expected all the Txns to fit in a block prior to OIP-8, got %d", len(fitted))
+	}
+	_, err = state.AddBlock(mineTestBlock(t, state, miner, fitted...))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestState starts a State from the genesis, every OIP is active from height 0 unless the genesis sets its forks
+func newTestState(t *testing.T, genesis Genesis) *State {
+	if genesis.Forks == nil {
+		genesis.Forks = testForks(nil)
+	}
+	dataDir := t.TempDir()
+	content, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxFutureBlockTime is how far in seconds a block time can be ahead of the local clock since OIP-4
+	MaxFutureBlockTime = 2 * 60
+
+	// MedianTimeBlocks is the number of previous blocks whose median time a new block must exceed since OIP-4
+	MedianTimeBlocks = 11
+)
+
+// Clock tells the current time, tests replace it to check the time rules of the blocks
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// medianTime returns the median of the block times, 0 without any
+func medianTime(times []uint64) uint64 {
+	if len(times) == 0 {
+		return 0
+	}
+
+	sorted := append([]uint64{}, times...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[len(sorted)/2]
+}
+
+// addRecentTime keeps the time of an applied block, the times of the last MedianTimeBlocks blocks are kept
+func (s *State) addRecentTime(time uint64) {
+	times := append(append([]uint64{}, s.recentTimes...), time)
+	if len(times) > MedianTimeBlocks {
+		times = times[len(times)-MedianTimeBlocks:]
+	}
+	s.recentTimes = times
+}
+
+// MinNextBlockTime returns the earliest time the next block can have,
+// 0 prior to OIP-4 when block times are not checked
+func (s *State) MinNextBlockTime() uint64 {
+	if !s.Rules().IsOIP4 || len(s.recentTimes) == 0 {
+		return 0
+	}
+	return medianTime(s.recentTimes) + 1
+}
+
+// validateBlockTime checks the block comes after the median time of the previous blocks
+func (s *State) validateBlockTime(b Block) error {
+	if minTime := s.MinNextBlockTime(); b.Header.Time < minTime {
+		return fmt.Errorf("block time %d must be after the median time %d of the previous blocks", b.Header.Time, minTime-1)
+	}
+	return nil
+}
+
+// validateFutureBlockTime checks a new block isn't too far ahead of the local clock.
+// Unlike the other rules, it depends on when the block is received so stored blocks aren't checked again.
+func (s *State) validateFutureBlockTime(b Block) error {
+	if !s.chainConfig.IsActive(OIP4, b.Header.Height) {
+		return nil
+	}
+
+	maxTime := uint64(s.clock.Now().Unix()) + MaxFutureBlockTime
+	if b.Header.Time > maxTime {
+		return fmt.Errorf("block time %d is more than %d seconds in the future", b.Header.Time, MaxFutureBlockTime)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c testClock) Now() time.Time {
+	return c.now
+}
+
+func TestStateEnforcesBlockTime(t *testing.T) {
+	dataDir := setupTestDataDir(t, nil)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	now := uint64(1_000_000)
+
+	opts := DefaultOptions()
+	opts.Clock = testClock{time.Unix(int64(now), 0)}
+	opts.SnapshotInterval = 2
+
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = state.AddBlock(mineTestBlockAt(t, state, now+MaxFutureBlockTime+1, miner))
+	if err == nil || !strings.Contains(err.Error(), "future") {
+		t.Fatalf("a block too far in the future must be rejected, got error: %v", err)
+	}
+
+	// The median time of the blocks at 100, 200 and 300 is 200
+	for _, blockTime := range []uint64{100, 200, 300} {
+		_, err = state.AddBlock(mineTestBlockAt(t, state, blockTime, miner))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = state.AddBlock(mineTestBlockAt(t, state, 200, miner))
+	if err == nil || !strings.Contains(err.Error(), "median time") {
+		t.Fatalf("a block not after the median time must be rejected, got error: %v", err)
+	}
+	state.Close()
+
+	// The block times are restored from the snapshot
+	state, err = NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.MinNextBlockTime() != 201 {
+		t.Fatalf("expected the next block to be after 200, got %d", state.MinNextBlockTime())
+	}
+	_, err = state.AddBlock(mineTestBlockAt(t, state, now+MaxFutureBlockTime, miner))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockTimeBeforeOIP4(t *testing.T) {
+	dataDir := t.TempDir()
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	genesis, err := json.Marshal(Genesis{Symbol: "OPB", Forks: testForks(map[string]uint64{OIP4: ForkDisabled})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Clock = testClock{time.Unix(0, 0)}
+
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	// Any block time is valid prior to the fork
+	for _, blockTime := range []uint64{500, 100} {
+		_, err = state.AddBlock(mineTestBlockAt(t, state, blockTime, miner))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
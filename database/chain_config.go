@@ -0,0 +1,240 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ForkDisabled is the fork height of a fork never activated on the chain
+const ForkDisabled = math.MaxUint64
+
+// Names of the OIPs activated by a fork, the fork_<name> keys of genesis.json
+const (
+	OIP1  = "oip_1"
+	OIP2  = "oip_2"
+	OIP3  = "oip_3"
+	OIP4  = "oip_4"
+	OIP5  = "oip_5"
+	OIP6  = "oip_6"
+	OIP8  = "oip_8"
+	OIP9  = "oip_9"
+	OIP10 = "oip_10"
+	OIP11 = "oip_11"
+)
+
+// forkKeyPrefix prefixes the name of an OIP in the JSON key of its activation height
+const forkKeyPrefix = "fork_"
+
+// oipSpec describes an OIP activated by a fork
+type oipSpec struct {
+	name string
+	// requires is the OIP that must be active once this one is, when it builds on it
+	requires string
+	// missing is the activation height of the OIP in a genesis.json written before it was introduced
+	missing uint64
+}
+
+// oips are the OIPs known to the node, in activation order. Adding an OIP means adding it
+// here and to Rules.
+var oips = []oipSpec{
+	// OIP-1 predates the fork settings, all the chains define it
+	{OIP1, "", 0},
+	{OIP2, "", ForkDisabled},
+	// The difficulty is part of the block header encoding introduced by OIP-2
+	{OIP3, OIP2, ForkDisabled},
+	{OIP4, "", ForkDisabled},
+	// So is the Txn root
+	{OIP5, OIP2, ForkDisabled},
+	// The state root is only committed to by the header hash of OIP-5
+	{OIP6, OIP5, ForkDisabled},
+	{OIP8, "", ForkDisabled},
+	{OIP9, "", ForkDisabled},
+	// The chain ID is only part of the RLP Txn encoding
+	{OIP10, OIP2, ForkDisabled},
+	// The coinbase Txn is unsigned, the legacy JSON encoding tells an empty signature from a missing one
+	{OIP11, OIP2, ForkDisabled},
+}
+
+// ChainConfig holds the activation heights of the OIPs of the chain, loaded from genesis.json
+type ChainConfig struct {
+	forks map[string]uint64
+	// limits are the block limits applying since OIP-8
+	limits BlockLimits
+	// checkpoints pin the hashes of blocks of the chain, sorted by increasing height
+	checkpoints []Checkpoint
+}
+
+// NewChainConfig validates the activation heights of the OIPs and the checkpoints. An OIP missing from the forks
+// gets the height it had before it was introduced, see oipSpec.missing. The limits default to DefaultBlockGasLimit
+// and DefaultMaxBlockSize.
+func NewChainConfig(forks map[string]uint64, limits *BlockLimits, checkpoints []Checkpoint) (ChainConfig, error) {
+	if limits == nil {
+		limits = &defaultBlockLimits
+	}
+	err := validateCheckpoints(checkpoints)
+	if err != nil {
+		return ChainConfig{}, err
+	}
+	c := ChainConfig{make(map[string]uint64), *limits, checkpoints}
+	for _, oip := range oips {
+		c.forks[oip.name] = oip.missing
+	}
+	for name, height := range forks {
+		if !isKnownOIP(name) {
+			return ChainConfig{}, fmt.Errorf("unknown fork %s%s", forkKeyPrefix, name)
+		}
+		c.forks[name] = height
+	}
+
+	for _, oip := range oips {
+		if oip.requires != "" && c.ForkHeight(oip.name) < c.ForkHeight(oip.requires) {
+			return ChainConfig{}, fmt.Errorf("%s%s can't activate before %s%s", forkKeyPrefix, oip.name, forkKeyPrefix, oip.requires)
+		}
+	}
+	return c, nil
+}
+
+func isKnownOIP(name string) bool {
+	for _, oip := range oips {
+		if oip.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ForkHeight returns the height of the first block the OIP applies to, ForkDisabled if it never does
+func (c ChainConfig) ForkHeight(name string) uint64 {
+	return c.forks[name]
+}
+
+// IsActive tells if the OIP applies to the block at the height
+func (c ChainConfig) IsActive(name string, height uint64) bool {
+	return height >= c.ForkHeight(name)
+}
+
+// Equal tells if both configs activate the same OIPs at the same heights, the State doesn't depend on the limits
+func (c ChainConfig) Equal(other ChainConfig) bool {
+	for _, oip := range oips {
+		if c.ForkHeight(oip.name) != other.ForkHeight(oip.name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules are the OIPs applying to a block, resolved once from the ChainConfig for its height
+type Rules struct {
+	Height  uint64
+	IsOIP1  bool
+	IsOIP2  bool
+	IsOIP3  bool
+	IsOIP4  bool
+	IsOIP5  bool
+	IsOIP6  bool
+	IsOIP8  bool
+	IsOIP9  bool
+	IsOIP10 bool
+	IsOIP11 bool
+}
+
+// Rules returns the OIPs applying to the block at the height
+func (c ChainConfig) Rules(height uint64) Rules {
+	return Rules{
+		Height:  height,
+		IsOIP1:  c.IsActive(OIP1, height),
+		IsOIP2:  c.IsActive(OIP2, height),
+		IsOIP3:  c.IsActive(OIP3, height),
+		IsOIP4:  c.IsActive(OIP4, height),
+		IsOIP5:  c.IsActive(OIP5, height),
+		IsOIP6:  c.IsActive(OIP6, height),
+		IsOIP8:  c.IsActive(OIP8, height),
+		IsOIP9:  c.IsActive(OIP9, height),
+		IsOIP10: c.IsActive(OIP10, height),
+		IsOIP11: c.IsActive(OIP11, height),
+	}
+}
+
+// Fork describes the activation of an OIP on the chain
+type Fork struct {
+	Name string `json:"name"`
+	// Height is nil for a fork never activated on the chain
+	Height *uint64 `json:"height"`
+	Active bool    `json:"active"`
+}
+
+// Forks returns the activation of all the known OIPs for the block at the height
+func (c ChainConfig) Forks(height uint64) []Fork {
+	forks := make([]Fork, len(oips))
+	for i, oip := range oips {
+		forks[i] = Fork{oip.name, nil, c.IsActive(oip.name, height)}
+		if forkHeight := c.ForkHeight(oip.name); forkHeight != ForkDisabled {
+			forks[i].Height = &forkHeight
+		}
+	}
+	return forks
+}
+
+// ChainConfig returns the activation heights of the OIPs of the chain
+func (s *State) ChainConfig() ChainConfig {
+	return s.chainConfig
+}
+
+// Rules returns the OIPs applying to the next block
+func (s *State) Rules() Rules {
+	return s.chainConfig.Rules(s.NextBlockHeight())
+}
+
+// readForks reads the fork_<name> keys of the JSON object. Known OIPs missing from it get the
+// height they had before they were introduced.
+func readForks(object []byte) (map[string]uint64, error) {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(object, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	forks := make(map[string]uint64)
+	for _, oip := range oips {
+		forks[oip.name] = oip.missing
+	}
+	for key, value := range keys {
+		if !strings.HasPrefix(key, forkKeyPrefix) {
+			continue
+		}
+		var height uint64
+		err = json.Unmarshal(value, &height)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", key, err)
+		}
+		forks[strings.TrimPrefix(key, forkKeyPrefix)] = height
+	}
+	return forks, nil
+}
+
+// writeForks adds the fork_<name> keys of all the known OIPs to the JSON object. The OIPs missing
+// from the forks get the height they had before they were introduced, like readForks reads them back.
+func writeForks(object []byte, forks map[string]uint64) ([]byte, error) {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(object, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	heights := make(map[string]uint64)
+	for _, oip := range oips {
+		heights[oip.name] = oip.missing
+	}
+	for name, height := range forks {
+		heights[name] = height
+	}
+	for name, height := range heights {
+		keys[forkKeyPrefix+name], err = json.Marshal(height)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(keys)
+}
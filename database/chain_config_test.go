@@ -0,0 +1,98 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestChainConfig(t *testing.T) {
+	config, err := NewChainConfig(map[string]uint64{OIP1: 10, OIP2: 20, OIP3: 20, OIP4: ForkDisabled, OIP5: 30, OIP6: 30, OIP8: 30, OIP9: 30, OIP10: 30, OIP11: 30}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Rules{Height: 19, IsOIP1: true, IsOIP2: false, IsOIP3: false, IsOIP4: false, IsOIP5: false, IsOIP6: false, IsOIP8: false, IsOIP9: false, IsOIP10: false, IsOIP11: false}
+	if rules := config.Rules(19); rules != expected {
+		t.Fatalf("expected the rules %+v, got %+v", expected, rules)
+	}
+	expected = Rules{Height: 20, IsOIP1: true, IsOIP2: true, IsOIP3: true, IsOIP4: false, IsOIP5: false, IsOIP6: false, IsOIP8: false, IsOIP9: false, IsOIP10: false, IsOIP11: false}
+	if rules := config.Rules(20); rules != expected {
+		t.Fatalf("expected the rules %+v, got %+v", expected, rules)
+	}
+
+	forks := config.Forks(15)
+	if len(forks) != 10 || forks[0].Name != OIP1 || !forks[0].Active || *forks[0].Height != 10 {
+		t.Fatalf("unexpected fork %+v", forks[0])
+	}
+	if forks[3].Name != OIP4 || forks[3].Active || forks[3].Height != nil {
+		t.Fatalf("a disabled fork must have no height, got %+v", forks[3])
+	}
+
+	_, err = NewChainConfig(map[string]uint64{OIP2: 20, OIP3: 10}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "fork_oip_3 can't activate before fork_oip_2") {
+		t.Fatalf("an OIP activating before the OIP it builds on must be rejected, got error: %v", err)
+	}
+	_, err = NewChainConfig(map[string]uint64{"oip_99": 10}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown fork") {
+		t.Fatalf("an unknown OIP must be rejected, got error: %v", err)
+	}
+}
+
+func TestGenesisForks(t *testing.T) {
+	var genesis Genesis
+	err := json.Unmarshal([]byte(`{"symbol": "OPB", "fork_oip_1": 10, "fork_oip_2": 20}`), &genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Symbol != "OPB" {
+		t.Fatalf("expected the symbol OPB, got %s", genesis.Symbol)
+	}
+
+	// The forks missing from the genesis.json stay disabled
+	expected := map[string]uint64{OIP1: 10, OIP2: 20, OIP3: ForkDisabled, OIP4: ForkDisabled, OIP5: ForkDisabled, OIP6: ForkDisabled, OIP8: ForkDisabled, OIP9: ForkDisabled, OIP10: ForkDisabled, OIP11: ForkDisabled}
+	if len(genesis.Forks) != len(expected) {
+		t.Fatalf("expected the forks %v, got %v", expected, genesis.Forks)
+	}
+	for name, height := range expected {
+		if genesis.Forks[name] != height {
+			t.Fatalf("expected %s at height %d, got %d", name, height, genesis.Forks[name])
+		}
+	}
+
+	content, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Genesis
+	err = json.Unmarshal(content, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, height := range expected {
+		if decoded.Forks[name] != height {
+			t.Fatalf("expected %s at height %d once encoded again, got %d", name, height, decoded.Forks[name])
+		}
+	}
+
+	// A Genesis built in code gets the same defaults once written and read back
+	content, err = json.Marshal(Genesis{Symbol: "OPB", Forks: map[string]uint64{OIP2: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(content, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewChainConfig(map[string]uint64{OIP2: 20}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readConfig, err := NewChainConfig(decoded.Forks, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Equal(readConfig) || config.ForkHeight(OIP1) != 0 || config.ForkHeight(OIP3) != ForkDisabled {
+		t.Fatalf("expected fork_oip_1 at 0 and the missing forks disabled both ways, got %v and %v", config.Forks(0), readConfig.Forks(0))
+	}
+}
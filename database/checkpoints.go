@@ -0,0 +1,81 @@
+package database
+
+import (
+	"fmt"
+)
+
+// Checkpoint pins the hash of the block of the chain at the height in genesis.json
+type Checkpoint struct {
+	Height uint64 `json:"height"`
+	Hash   Hash   `json:"hash"`
+}
+
+func validateCheckpoints(checkpoints []Checkpoint) error {
+	for i := 1; i < len(checkpoints); i++ {
+		if checkpoints[i].Height <= checkpoints[i-1].Height {
+			return fmt.Errorf("the checkpoints must be sorted by increasing height")
+		}
+	}
+	return nil
+}
+
+// Checkpoints returns the blocks pinned by the chain. A block at the height of a checkpoint with another
+// hash is rejected, and so are the branches replacing a checkpoint block of the main chain.
+func (c ChainConfig) Checkpoints() []Checkpoint {
+	return c.checkpoints
+}
+
+// ValidateCheckpoint checks the block with the hash at the height doesn't contradict a checkpoint
+func (c ChainConfig) ValidateCheckpoint(height uint64, hash Hash) error {
+	for _, checkpoint := range c.checkpoints {
+		if checkpoint.Height == height && checkpoint.Hash != hash {
+			return fmt.Errorf("block %s at height %d doesn't match the checkpoint %s", hash.Hex(), height, checkpoint.Hash.Hex())
+		}
+	}
+	return nil
+}
+
+// latestCheckpointBelow returns the checkpoint with the highest height below the height, ok is false without one
+func (c ChainConfig) latestCheckpointBelow(height uint64) (Checkpoint, bool) {
+	for i := len(c.checkpoints) - 1; i >= 0; i-- {
+		if c.checkpoints[i].Height < height {
+			return c.checkpoints[i], true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// TrustCheckpointedBranch looks for the block of the latest checkpoint among the blocks of a branch and walks
+// the parent hashes back from it. The Txn signatures of the blocks found are not recovered when they are applied,
+// the hash of the checkpoint block commits to them. The blocks of a branch without the checkpoint block, or
+// not leading to it, are fully validated.
+func (s *State) TrustCheckpointedBranch(blocks []Block) error {
+	if len(s.chainConfig.checkpoints) == 0 {
+		return nil
+	}
+	checkpoint := s.chainConfig.checkpoints[len(s.chainConfig.checkpoints)-1]
+
+	byHash := make(map[Hash]Block, len(blocks))
+	for _, b := range blocks {
+		hash, err := b.Hash()
+		if err != nil {
+			return err
+		}
+		byHash[hash] = b
+	}
+
+	hash := checkpoint.Hash
+	height := checkpoint.Height
+	for {
+		b, ok := byHash[hash]
+		if !ok || b.Header.Height != height {
+			return nil
+		}
+		s.checkpointed[hash] = struct{}{}
+		if height == 0 {
+			return nil
+		}
+		hash = b.Header.Parent
+		height--
+	}
+}
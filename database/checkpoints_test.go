@@ -0,0 +1,104 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	_, err := NewChainConfig(nil, nil, []Checkpoint{{2, Hash{2}}, {1, Hash{1}}})
+	if err == nil {
+		t.Fatal("the checkpoints must be sorted by increasing height")
+	}
+
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	otherMiner := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+	_, sender := newTestAccount(t)
+	otherKey, _ := newTestAccount(t)
+	balances := map[common.Address]Amount{sender: NewAmount(1000)}
+
+	source, err := NewStateFromDisk(setupTestDataDir(t, balances))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	var blocks []Block
+	var hashes []Hash
+	for i := 0; i < 3; i++ {
+		block := mineTestBlock(t, source, miner)
+		hash, err := source.AddBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		hashes = append(hashes, hash)
+	}
+
+	state := newTestState(t, Genesis{Balances: balances, Symbol: "OPB", Checkpoints: []Checkpoint{{2, hashes[2]}}})
+	defer state.Close()
+
+	// A branch ending below the checkpoint isn't trusted, its forged Txns are rejected
+	if err = state.TrustCheckpointedBranch(blocks[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.checkpointed) != 0 {
+		t.Fatal("a branch without the checkpoint block must not be trusted")
+	}
+	forgedTxns, err := state.AddCoinbaseTxn(otherMiner, []SignedTxn{signTestTxn(t, newTestTxn(sender, otherMiner, NewAmount(10), 1, ""), otherKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := mineTestBlock(t, state, otherMiner, forgedTxns[1:]...)
+	pending := state.Copy()
+	if err = applyBlockTxns(forged.Txns, otherMiner, &pending, false); err != nil {
+		t.Fatal(err)
+	}
+	if forged.Header.StateRoot, err = pending.stateRoot(); err != nil {
+		t.Fatal(err)
+	}
+	for !forged.Header.IsHashValid(mustBlockHash(t, forged)) {
+		forged.Header.Nonce++
+	}
+	if _, err = state.AddBlock(forged); err == nil || !strings.Contains(err.Error(), "forged") {
+		t.Fatalf("a forged Txn below the checkpoint must be rejected, got error: %v", err)
+	}
+
+	// The blocks leading to the checkpoint block are trusted until they are applied
+	if err = state.TrustCheckpointedBranch(blocks); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.checkpointed) != 3 {
+		t.Fatalf("expected the 3 blocks leading to the checkpoint trusted, got %d", len(state.checkpointed))
+	}
+	if err = state.AddBlocks(blocks[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.checkpointed) != 1 {
+		t.Fatalf("expected the applied blocks not to be trusted anymore, got %d", len(state.checkpointed))
+	}
+
+	fake := mineTestBlock(t, state, otherMiner)
+	if _, err = state.AddBlock(fake); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Fatalf("a block contradicting a checkpoint must be rejected, got error: %v", err)
+	}
+	if _, err = state.ImportBlock(fake); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Fatalf("a block contradicting a checkpoint must not be imported, got error: %v", err)
+	}
+
+	// Once the main chain holds the checkpoint block, no branch can fork below it
+	side := newTestState(t, Genesis{Balances: balances, Symbol: "OPB"})
+	defer side.Close()
+	if _, err = side.AddBlock(blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	sideBlock := mineTestBlock(t, side, otherMiner)
+
+	if _, err = state.AddBlock(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = state.ImportBlock(sideBlock); err == nil || !strings.Contains(err.Error(), "below the checkpoint") {
+		t.Fatalf("a branch replacing a checkpoint block must be rejected, got error: %v", err)
+	}
+}
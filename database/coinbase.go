@@ -0,0 +1,65 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"math"
+)
+
+// coinbaseData marks the coinbase Txn of a block, see Txn.IsReward
+const coinbaseData = "reward"
+
+// newCoinbaseTxn returns the coinbase Txn crediting the value to the miner of the block at the height.
+// It's not signed, its nonce is the block height so the coinbase Txns of the chain have distinct hashes.
+func newCoinbaseTxn(miner common.Address, height uint64, value Amount, version uint8) SignedTxn {
+	return SignedTxn{Txn{common.Address{}, miner, 0, Amount{}, value, uint(height), coinbaseData, 0, version, ""}, nil}
+}
+
+// splitCoinbase separates the coinbase Txn from the other Txns of a block, the coinbase is nil prior to OIP-11
+func splitCoinbase(txns []SignedTxn, rules Rules) (*SignedTxn, []SignedTxn) {
+	if !rules.IsOIP11 || len(txns) == 0 || !txns[0].IsReward() {
+		return nil, txns
+	}
+	return &txns[0], txns[1:]
+}
+
+// AddCoinbaseTxn returns the Txns of the next block mined by the miner, starting with the
+// coinbase Txn crediting the block reward and the Txn fees since OIP-11
+func (s *State) AddCoinbaseTxn(miner common.Address, txns []SignedTxn) ([]SignedTxn, error) {
+	rules := s.Rules()
+	if !rules.IsOIP11 {
+		return txns, nil
+	}
+
+	value, err := s.blockRewardAndFees(Block{Txns: txns}, rules)
+	if err != nil {
+		return nil, err
+	}
+	coinbase := newCoinbaseTxn(miner, rules.Height, value, s.EncodingVersion())
+	return append([]SignedTxn{coinbase}, txns...), nil
+}
+
+// validateCoinbase checks the coinbase Txn of the next block credits exactly the value to the miner
+func validateCoinbase(coinbase SignedTxn, miner common.Address, value Amount, s *State) error {
+	height := s.NextBlockHeight()
+	if len(coinbase.Sig) > 0 {
+		return fmt.Errorf("invalid coinbase Txn at height %d, it can't be signed", height)
+	}
+
+	expected := newCoinbaseTxn(miner, height, value, s.EncodingVersion())
+	if coinbase.Txn != expected.Txn {
+		return fmt.Errorf("invalid coinbase Txn at height %d, it must credit %s to %s with nonce %d", height, value, miner, height)
+	}
+	return nil
+}
+
+// maxCoinbaseSize returns the largest encoding of a coinbase Txn in a block
+func maxCoinbaseSize(version uint8) (int, error) {
+	maxAmount := Amount(*new(uint256.Int).SetAllOne())
+	encoded, err := encodeSignedTxn(newCoinbaseTxn(common.Address{}, math.MaxUint64, maxAmount, version))
+	if err != nil {
+		return 0, err
+	}
+	return len(encoded) + 1, nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestCoinbaseTxn(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	genesis := Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB"}
+	state := newTestState(t, genesis)
+	defer state.Close()
+
+	txn := signTestTxn(t, newTestTxn(sender, miner, NewAmount(10), 1, ""), key)
+
+	// The coinbase Txn can't be left out, nor credit more than the reward and the fees
+	block := mineTestBlock(t, state, miner, txn)
+	block.Txns = block.Txns[1:]
+	_, err := state.AddBlock(remineTestBlock(t, block))
+	if err == nil || !strings.Contains(err.Error(), "coinbase") {
+		t.Fatalf("a block without coinbase Txn must be rejected, got error: %v", err)
+	}
+
+	block = mineTestBlock(t, state, miner, txn)
+	block.Txns[0].Value = NewAmount(Reward + TxnGas*DefaultGasPrice + 1)
+	_, err = state.AddBlock(remineTestBlock(t, block))
+	if err == nil || !strings.Contains(err.Error(), "invalid coinbase Txn") {
+		t.Fatalf("a coinbase Txn crediting more than the reward and the fees must be rejected, got error: %v", err)
+	}
+
+	block = mineTestBlock(t, state, miner, txn)
+	blockHash, err := state.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := block.Txns[0]
+	if !coinbase.IsReward() || coinbase.To != miner || coinbase.Value != NewAmount(Reward+TxnGas*DefaultGasPrice) {
+		t.Fatalf("expected a coinbase Txn crediting %d to the miner, got %+v", Reward+TxnGas*DefaultGasPrice, coinbase.Txn)
+	}
+	if state.Balances[miner] != NewAmount(10+Reward+TxnGas*DefaultGasPrice) {
+		t.Fatalf("expected the miner balance %d, got %s", 10+Reward+TxnGas*DefaultGasPrice, state.Balances[miner])
+	}
+
+	// The reward and the fees credited to the miner link to the coinbase Txn
+	coinbaseHash := mustTxnHash(t, coinbase.Txn)
+	minedTxn, err := state.GetMinedTxn(coinbaseHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minedTxn.BlockHash != blockHash || minedTxn.Index != 0 {
+		t.Fatalf("expected the coinbase Txn first in block %s, got %+v", blockHash.Hex(), minedTxn)
+	}
+	entries, _, err := state.GetAccountTxns(miner, "", 0, DirectionIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Kind != AccountTxnTransfer && (entry.TxnHash == nil || *entry.TxnHash != coinbaseHash) {
+			t.Fatalf("expected the %s entry to link to the coinbase Txn, got %+v", entry.Kind, entry)
+		}
+	}
+
+	// Prior to OIP-11 the reward and the fees are credited without coinbase Txn
+	genesis.Forks = testForks(map[string]uint64{OIP11: ForkDisabled})
+	legacyState := newTestState(t, genesis)
+	defer legacyState.Close()
+
+	block = mineTestBlock(t, legacyState, miner, txn)
+	if len(block.Txns) != 1 {
+		t.Fatalf("expected no coinbase Txn prior to OIP-11, got %d Txns", len(block.Txns))
+	}
+	if _, err = legacyState.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if legacyState.Balances[miner] != state.Balances[miner] {
+		t.Fatalf("expected the same miner balance without coinbase Txn, got %s", legacyState.Balances[miner])
+	}
+}
+
+// remineTestBlock mines the block again once its Txns were changed, with the Txn root of the new Txns
+func remineTestBlock(t *testing.T, block Block) Block {
+	txRoot, err := ComputeTxRoot(block.Txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Header.TxRoot = txRoot
+
+	for block.Header.Nonce = 0; ; block.Header.Nonce++ {
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Header.IsHashValid(hash) {
+			return block
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Consensus engines selected by the chain in genesis.json
+const (
+	// ConsensusPoW seals blocks with a proof of work, the default engine
+	ConsensusPoW = "pow"
+	// ConsensusPoA seals blocks with the signature of an authorized sealer, see OIP-7
+	ConsensusPoA = "poa"
+)
+
+// Difficulties of the proof of authority blocks, the heaviest chain is the one with the most in-turn blocks
+const (
+	DifficultyInTurn    = 2
+	DifficultyOutOfTurn = 1
+)
+
+// ConsensusConfig selects the consensus engine of the chain in genesis.json
+type ConsensusConfig struct {
+	Engine string `json:"engine"`
+	// Sealers are the accounts authorized to seal the blocks of a proof of authority chain, in turn order
+	Sealers []common.Address `json:"sealers,omitempty"`
+}
+
+// Consensus is the engine deciding which blocks are valid to extend the chain and who can seal them
+type Consensus interface {
+	// Engine returns the name of the engine, ConsensusPoW or ConsensusPoA
+	Engine() string
+
+	// Difficulty returns the difficulty of the next block on top of the State sealed by the sealer at the time
+	Difficulty(s *State, sealer common.Address, time uint64) uint64
+
+	// VerifyHeader validates the consensus fields of the header of the next block on top of the State
+	VerifyHeader(s *State, h BlockHeader) error
+
+	// VerifySeal validates the proof of work or the sealer signature of the block with the hash.
+	// It doesn't depend on the State, so the blocks of side branches are checked too.
+	VerifySeal(b Block, hash Hash) error
+
+	// Finalize updates the consensus data of the State once the block with the header was applied
+	Finalize(s *State, h BlockHeader)
+}
+
+// newConsensus returns the engine configured by the genesis, proof of work without configuration
+func newConsensus(genesis Genesis, chainConfig ChainConfig) (Consensus, error) {
+	if genesis.Consensus == nil {
+		return powEngine{}, nil
+	}
+
+	switch genesis.Consensus.Engine {
+	case ConsensusPoW, "":
+		return powEngine{}, nil
+	case ConsensusPoA:
+		if len(genesis.Consensus.Sealers) == 0 {
+			return nil, fmt.Errorf("the proof of authority consensus requires at least 1 sealer")
+		}
+		// The seal is part of the RLP block header
+		if chainConfig.ForkHeight(OIP2) != 0 {
+			return nil, fmt.Errorf("the proof of authority consensus requires fork_oip_2 at height 0")
+		}
+		return &poaEngine{genesis.Consensus.Sealers}, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %s", genesis.Consensus.Engine)
+	}
+}
+
+// Consensus returns the consensus engine of the chain
+func (s *State) Consensus() Consensus {
+	return s.consensus
+}
+
+// NextDifficulty returns the difficulty required for the next block sealed by the sealer at the time
+func (s *State) NextDifficulty(sealer common.Address, time uint64) uint64 {
+	return s.consensus.Difficulty(s, sealer, time)
+}
+
+// powEngine validates the proof of work of the blocks, with the difficulty adjustment of OIP-3
+type powEngine struct{}
+
+func (powEngine) Engine() string {
+	return ConsensusPoW
+}
+
+// Difficulty returns 0 prior to OIP-3, when blocks have a fixed proof of work
+func (powEngine) Difficulty(s *State, sealer common.Address, time uint64) uint64 {
+	if !s.Rules().IsOIP3 {
+		return 0
+	}
+	if !s.hasGenesisBlock {
+		return MinDifficulty
+	}
+	return NextDifficulty(s.latestBlock.Header, time)
+}
+
+func (e powEngine) VerifyHeader(s *State, h BlockHeader) error {
+	if difficulty := e.Difficulty(s, h.Miner, h.Time); h.Difficulty != difficulty {
+		return fmt.Errorf("block at height %d must have difficulty %d not %d", h.Height, difficulty, h.Difficulty)
+	}
+	return nil
+}
+
+func (powEngine) VerifySeal(b Block, hash Hash) error {
+	if len(b.Header.Seal) > 0 {
+		return fmt.Errorf("block at height %d of a proof of work chain can't have a seal", b.Header.Height)
+	}
+	// The hash is checked against the difficulty of the header, side blocks would otherwise be kept almost for free
+	if b.Header.Difficulty != 0 && b.Header.Difficulty < MinDifficulty {
+		return fmt.Errorf("block at height %d has difficulty %d below the minimum %d", b.Header.Height, b.Header.Difficulty, MinDifficulty)
+	}
+	if !b.Header.IsHashValid(hash) {
+		return fmt.Errorf("invalid block hash %x", hash)
+	}
+	return nil
+}
+
+func (powEngine) Finalize(s *State, h BlockHeader) {}
+
+// poaEngine validates the blocks sealed by the authorized sealers taking turns, like Clique.
+// The in-turn sealer of a height seals with DifficultyInTurn. When it's late another sealer
+// can seal with DifficultyOutOfTurn, but no sealer can seal more than 1 of len(sealers)/2+1
+// consecutive blocks.
+type poaEngine struct {
+	sealers []common.Address
+}
+
+func (e *poaEngine) Engine() string {
+	return ConsensusPoA
+}
+
+func (e *poaEngine) inTurnSealer(height uint64) common.Address {
+	return e.sealers[height%uint64(len(e.sealers))]
+}
+
+func (e *poaEngine) isSealer(account common.Address) bool {
+	for _, sealer := range e.sealers {
+		if sealer == account {
+			return true
+		}
+	}
+	return false
+}
+
+// recentSealersKept is the number of previous blocks whose sealers can't seal the next block
+func (e *poaEngine) recentSealersKept() int {
+	return len(e.sealers) / 2
+}
+
+func (e *poaEngine) difficultyAt(height uint64, sealer common.Address) uint64 {
+	if e.inTurnSealer(height) == sealer {
+		return DifficultyInTurn
+	}
+	return DifficultyOutOfTurn
+}
+
+func (e *poaEngine) Difficulty(s *State, sealer common.Address, time uint64) uint64 {
+	return e.difficultyAt(s.NextBlockHeight(), sealer)
+}
+
+func (e *poaEngine) VerifyHeader(s *State, h BlockHeader) error {
+	if difficulty := e.Difficulty(s, h.Miner, h.Time); h.Difficulty != difficulty {
+		return fmt.Errorf("block at height %d must have difficulty %d not %d", h.Height, difficulty, h.Difficulty)
+	}
+	for _, recent := range s.recentSealers {
+		if recent == h.Miner {
+			return fmt.Errorf("sealer %s sealed one of the last %d blocks", h.Miner, e.recentSealersKept())
+		}
+	}
+	return nil
+}
+
+func (e *poaEngine) VerifySeal(b Block, hash Hash) error {
+	sealer, err := b.Sealer()
+	if err != nil {
+		return err
+	}
+	if sealer != b.Header.Miner {
+		return fmt.Errorf("block at height %d is sealed by %s, not by its miner %s", b.Header.Height, sealer, b.Header.Miner)
+	}
+	if !e.isSealer(sealer) {
+		return fmt.Errorf("block at height %d is sealed by %s, not an authorized sealer", b.Header.Height, sealer)
+	}
+	if difficulty := e.difficultyAt(b.Header.Height, sealer); b.Header.Difficulty != difficulty {
+		return fmt.Errorf("block at height %d must have difficulty %d not %d", b.Header.Height, difficulty, b.Header.Difficulty)
+	}
+	return nil
+}
+
+func (e *poaEngine) Finalize(s *State, h BlockHeader) {
+	sealers := append(append([]common.Address{}, s.recentSealers...), h.Miner)
+	if len(sealers) > e.recentSealersKept() {
+		sealers = sealers[len(sealers)-e.recentSealersKept():]
+	}
+	s.recentSealers = sealers
+}
+
+// loadRecentSealers reads the sealers of the last blocks up to the height from the store,
+// for snapshots taken before the sealers were part of them
+func (s *State) loadRecentSealers(height uint64) []common.Address {
+	e, ok := s.consensus.(*poaEngine)
+	if !ok || e.recentSealersKept() == 0 {
+		return nil
+	}
+
+	from := uint64(0)
+	if height+1 > uint64(e.recentSealersKept()) {
+		from = height + 1 - uint64(e.recentSealersKept())
+	}
+
+	var sealers []common.Address
+	_ = s.store.Iterate(from, height, func(blockFs BlockFS) error {
+		sealers = append(sealers, blockFs.Value.Header.Miner)
+		return nil
+	})
+	return sealers
+}
+
+// SealHash returns the hash signed by the sealer of a proof of authority block, the block hash without the seal
+func (b Block) SealHash() (Hash, error) {
+	b.Header.Seal = nil
+	return b.Hash()
+}
+
+// Sealer recovers the account that sealed the block from its seal
+func (b Block) Sealer() (common.Address, error) {
+	if len(b.Header.Seal) == 0 {
+		return common.Address{}, fmt.Errorf("block at height %d isn't sealed", b.Header.Height)
+	}
+
+	sealHash, err := b.SealHash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	publicKey, err := crypto.SigToPub(sealHash[:], b.Header.Seal)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid seal of block at height %d: %s", b.Header.Height, err)
+	}
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
+
+// SealBlock signs the block with the key of the sealer
+func SealBlock(b Block, key *ecdsa.PrivateKey) (Block, error) {
+	sealHash, err := b.SealHash()
+	if err != nil {
+		return Block{}, err
+	}
+
+	seal, err := crypto.Sign(sealHash[:], key)
+	if err != nil {
+		return Block{}, err
+	}
+	b.Header.Seal = seal
+	return b, nil
+}
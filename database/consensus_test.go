@@ -0,0 +1,148 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"strings"
+	"testing"
+)
+
+func TestProofOfAuthority(t *testing.T) {
+	keyA, sealerA := newTestAccount(t)
+	keyB, sealerB := newTestAccount(t)
+	keyC, sealerC := newTestAccount(t)
+	outsiderKey, _ := newTestAccount(t)
+
+	dataDir := setupTestPoADataDir(t, Genesis{Symbol: "OPB"}, sealerA, sealerB, sealerC)
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The in-turn sealer of height 0 is the first sealer
+	block := sealTestBlock(t, state, keyA)
+	if block.Header.Difficulty != DifficultyInTurn {
+		t.Fatalf("expected the in-turn difficulty %d, got %d", DifficultyInTurn, block.Header.Difficulty)
+	}
+	if _, err = state.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another sealer can seal out of turn
+	block = sealTestBlock(t, state, keyC)
+	if block.Header.Difficulty != DifficultyOutOfTurn {
+		t.Fatalf("expected the out-of-turn difficulty %d, got %d", DifficultyOutOfTurn, block.Header.Difficulty)
+	}
+	if _, err = state.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// But not seal 2 blocks in a row
+	_, err = state.AddBlock(sealTestBlock(t, state, keyC))
+	if err == nil || !strings.Contains(err.Error(), "sealed one of the last") {
+		t.Fatalf("a sealer sealing again too soon must be rejected, got error: %v", err)
+	}
+
+	_, err = state.AddBlock(sealTestBlock(t, state, outsiderKey))
+	if err == nil || !strings.Contains(err.Error(), "not an authorized sealer") {
+		t.Fatalf("a block sealed by an unknown account must be rejected, got error: %v", err)
+	}
+
+	forged := sealTestBlock(t, state, keyB)
+	forged.Header.Time++
+	_, err = state.AddBlock(forged)
+	if err == nil || !strings.Contains(err.Error(), "sealed by") {
+		t.Fatalf("a block changed after it was sealed must be rejected, got error: %v", err)
+	}
+
+	unsealed := sealTestBlock(t, state, keyB)
+	unsealed.Header.Seal = nil
+	_, err = state.AddBlock(unsealed)
+	if err == nil || !strings.Contains(err.Error(), "isn't sealed") {
+		t.Fatalf("a block without a seal must be rejected, got error: %v", err)
+	}
+
+	if _, err = state.AddBlock(sealTestBlock(t, state, keyA)); err != nil {
+		t.Fatal(err)
+	}
+	state.Close()
+
+	// The recent sealers are rebuilt from the stored blocks
+	state, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	_, err = state.AddBlock(sealTestBlock(t, state, keyA))
+	if err == nil || !strings.Contains(err.Error(), "sealed one of the last") {
+		t.Fatalf("a sealer sealing again too soon after a restart must be rejected, got error: %v", err)
+	}
+	if _, err = state.AddBlock(sealTestBlock(t, state, keyB)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProofOfAuthorityGenesis(t *testing.T) {
+	_, sealer := newTestAccount(t)
+
+	genesis := Genesis{Symbol: "OPB", Forks: map[string]uint64{OIP2: 10, OIP3: 10, OIP5: 10, OIP6: 10, OIP10: 10, OIP11: 10}}
+	_, err := NewStateFromDisk(setupTestPoADataDir(t, genesis, sealer))
+	if err == nil || !strings.Contains(err.Error(), "fork_oip_2") {
+		t.Fatalf("a proof of authority chain must use the RLP encoding from the start, got error: %v", err)
+	}
+
+	_, err = NewStateFromDisk(setupTestPoADataDir(t, Genesis{Symbol: "OPB"}))
+	if err == nil || !strings.Contains(err.Error(), "sealer") {
+		t.Fatalf("a proof of authority chain without sealers must be rejected, got error: %v", err)
+	}
+}
+
+func setupTestPoADataDir(t *testing.T, genesis Genesis, sealers ...common.Address) string {
+	dataDir := t.TempDir()
+
+	genesis.Consensus = &ConsensusConfig{ConsensusPoA, sealers}
+	if genesis.Forks == nil {
+		genesis.Forks = testForks(nil)
+	}
+	content, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataDir
+}
+
+// sealTestBlock seals a block with the given Txns on top of the given State with the key of the sealer
+func sealTestBlock(t *testing.T, s *State, key *ecdsa.PrivateKey, txns ...SignedTxn) Block {
+	sealer := crypto.PubkeyToAddress(key.PublicKey)
+	time := s.LatestBlock().Header.Time + TargetBlockTime
+	txns, err := s.AddCoinbaseTxn(sealer, txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := NewBlock(s.NextBlockHeight(), s.LatestBlockHash(), time, 0, sealer, txns)
+	block.Header.Version = s.EncodingVersion()
+	block.Header.Difficulty = s.NextDifficulty(sealer, time)
+
+	block.Header.TxRoot, err = s.TxRoot(txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Header.StateRoot, err = s.NextStateRoot(sealer, txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err = SealBlock(block, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
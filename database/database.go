@@ -1,91 +1,117 @@
 package database
 
 import (
-	"bufio"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
+	"github.com/ethereum/go-ethereum/common"
+	"math"
 	"reflect"
 )
 
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(
-		getBlocksDbFilePath(dataDir),
-		os.O_RDONLY,
-		0600,
-	)
-	if err != nil {
-		return nil, err
-	}
-
+func (s *State) GetBlocksAfter(blockHash Hash) ([]Block, error) {
 	blocks := make([]Block, 0)
-	shouldStartCollecting := false
+	fromHeight := uint64(0)
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
-	}
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		//read one line at a time
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	if !reflect.DeepEqual(blockHash, Hash{}) {
+		blockFs, err := s.store.GetByHash(blockHash)
+		var errPruned ErrPruned
+		if errors.As(err, &errPruned) {
+			return nil, fmt.Errorf("block %s is pruned: %w", blockHash.Hex(), err)
 		}
-
-		var blockFs BlockFS
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
 		if err != nil {
-			return nil, err
+			// unknown block, nothing to collect after it. Peers search for a common
+			// block with the hashes of their own chain, pruned nodes answer them too.
+			return blocks, nil
 		}
+		fromHeight = blockFs.Value.Header.Height + 1
+	}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
-		}
+	if fromHeight < s.prunedBelow() {
+		return nil, ErrPruned{s.prunedBelow()}
+	}
 
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
-		}
+	err := s.store.Iterate(fromHeight, math.MaxUint64, func(blockFs BlockFS) error {
+		blocks = append(blocks, blockFs.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return blocks, nil
 }
 
-func GetBlockByHashOrHeight(state *State, height uint64, hash, dataDir string) (BlockFS, error) {
-	var blockFs BlockFS
+func (s *State) GetBlockByHashOrHeight(height uint64, hash string) (BlockFS, error) {
+	if hash == "" {
+		return s.store.GetByHeight(height)
+	}
 
-	key, ok := state.HeightCache[height]
-	if hash != "" {
-		key, ok = state.HashCache[hash]
+	key, err := parseHash(hash)
+	if err != nil {
+		return BlockFS{}, err
 	}
+	return s.store.GetByHash(key)
+}
 
-	if !ok {
-		if hash != "" {
-			return blockFs, fmt.Errorf("invalid hash: %v", hash)
-		}
-		return blockFs, fmt.Errorf("invalid height: %v", height)
+// MinedTxn is a Txn found in the chain with the block it was mined in
+type MinedTxn struct {
+	Txn       SignedTxn `json:"txn"`
+	BlockHash Hash      `json:"block_hash"`
+	Height    uint64    `json:"block_height"`
+	Index     uint32    `json:"index"`
+}
+
+// GetMinedTxn looks up a mined Txn by its hash in the Txn index
+func (s *State) GetMinedTxn(hash string) (MinedTxn, error) {
+	key, err := parseHash(hash)
+	if err != nil {
+		return MinedTxn{}, err
 	}
 
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_RDONLY, 0600)
+	location, err := s.indexes.getTxnLocation(key)
 	if err != nil {
-		return blockFs, err
+		return MinedTxn{}, err
+	}
+	if location.Height < s.prunedBelow() {
+		return MinedTxn{}, fmt.Errorf("txn %s was mined at height %d: %w", hash, location.Height, ErrPruned{s.prunedBelow()})
 	}
-	defer f.Close()
 
-	_, err = f.Seek(key, 0)
+	blockFs, err := s.store.GetByHeight(location.Height)
 	if err != nil {
-		return blockFs, err
+		return MinedTxn{}, err
+	}
+	if int(location.Index) >= len(blockFs.Value.Txns) {
+		return MinedTxn{}, fmt.Errorf("the Txn index of %s is out of date", hash)
 	}
 
-	scanner := bufio.NewScanner(f)
-	if scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return blockFs, err
-		}
+	// The block at the height may have replaced the one the Txn was indexed in
+	txn := blockFs.Value.Txns[location.Index]
+	txnHash, err := txn.Hash()
+	if err != nil {
+		return MinedTxn{}, err
+	}
+	if txnHash != key {
+		return MinedTxn{}, fmt.Errorf("the Txn index of %s is out of date", hash)
+	}
 
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
-		if err != nil {
-			return blockFs, err
-		}
+	return MinedTxn{txn, blockFs.Key, location.Height, location.Index}, nil
+}
+
+func parseHash(hash string) (Hash, error) {
+	var key Hash
+	if len(hash) != hex.EncodedLen(len(key)) {
+		return Hash{}, fmt.Errorf("invalid hash: %v", hash)
+	}
+
+	err := key.UnmarshalText([]byte(hash))
+	if err != nil {
+		return Hash{}, fmt.Errorf("invalid hash: %v", hash)
 	}
-	return blockFs, nil
+	return key, nil
+}
+
+// GetAccountTxns returns a page of the history of the account, see chainIndexes.getAccountTxns.
+// A pruned node only lists the history of the blocks it keeps.
+func (s *State) GetAccountTxns(account common.Address, cursor string, limit int, direction string) ([]AccountTxn, string, error) {
+	return s.indexes.getAccountTxns(account, s.prunedBelow(), cursor, limit, direction)
 }
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"github.com/holiman/uint256"
+	"regexp"
+)
+
+const (
+	// MinDifficulty is the difficulty of the proof of work prior to OIP-3, 16^blockDifficulty
+	// hashes on average, and the difficulty of the first block starting at the fork
+	MinDifficulty = uint64(1) << (4 * blockDifficulty)
+
+	// TargetBlockTime is the time in seconds the difficulty adjustment aims for between 2 blocks
+	TargetBlockTime = 10
+
+	// difficultyBoundDivisor bounds the difficulty change of a block to 1/difficultyBoundDivisor
+	// of its parent difficulty per TargetBlockTime of deviation. A slow block drops it at most
+	// maxDifficultyDrop times, so by half.
+	difficultyBoundDivisor = 64
+	maxDifficultyDrop      = 32
+)
+
+var maxTarget = new(uint256.Int).SetAllOne()
+
+// DifficultyTarget returns the highest valid block hash for the difficulty,
+// 2^256 / difficulty hashes are tried on average to find a valid one
+func DifficultyTarget(difficulty uint64) *uint256.Int {
+	if difficulty == 0 {
+		return new(uint256.Int).Set(maxTarget)
+	}
+	return new(uint256.Int).Div(maxTarget, uint256.NewInt(difficulty))
+}
+
+// IsBlockHashValid validates that the block hash, read as a big endian number, doesn't exceed the target
+func IsBlockHashValid(hash Hash, target *uint256.Int) bool {
+	return new(uint256.Int).SetBytes32(hash[:]).Cmp(target) <= 0
+}
+
+// isLegacyBlockHashValid validates that the block hash starts with exactly blockDifficulty leading zeros,
+// the proof of work of the blocks prior to OIP-3
+func isLegacyBlockHashValid(hash Hash) bool {
+	hexHash := hash.Hex()
+	pattern := "^0*"
+
+	re := regexp.MustCompile(pattern)
+	match := re.FindString(hexHash)
+	return len(match) == blockDifficulty
+}
+
+// IsHashValid validates the proof of work of the block hash against the difficulty of the header
+func (h BlockHeader) IsHashValid(hash Hash) bool {
+	if h.Difficulty == 0 {
+		return isLegacyBlockHashValid(hash)
+	}
+	return IsBlockHashValid(hash, DifficultyTarget(h.Difficulty))
+}
+
+// NextDifficulty returns the difficulty of a block mined at the time on top of the parent.
+// The difficulty rises when the block comes faster than TargetBlockTime after its parent
+// and drops when it comes slower, it never goes below MinDifficulty.
+func NextDifficulty(parent BlockHeader, time uint64) uint64 {
+	difficulty := parent.Difficulty
+	if difficulty < MinDifficulty {
+		difficulty = MinDifficulty
+	}
+
+	elapsed := uint64(0)
+	if time > parent.Time {
+		elapsed = time - parent.Time
+	}
+
+	adjustment := int64(1)
+	if elapsed/TargetBlockTime > maxDifficultyDrop+1 {
+		adjustment = -maxDifficultyDrop
+	} else {
+		adjustment -= int64(elapsed / TargetBlockTime)
+	}
+
+	step := difficulty / difficultyBoundDivisor
+	if adjustment >= 0 {
+		return difficulty + step*uint64(adjustment)
+	}
+
+	drop := step * uint64(-adjustment)
+	if difficulty-MinDifficulty < drop {
+		return MinDifficulty
+	}
+	return difficulty - drop
+}
+
+// Work returns the expected number of hashes tried to mine the block, the heaviest chain is the one
+// with the most accumulated work
+func (h BlockHeader) Work() uint64 {
+	if h.Difficulty == 0 {
+		return MinDifficulty
+	}
+	return h.Difficulty
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNextDifficulty(t *testing.T) {
+	parent := BlockHeader{Time: 1000, Difficulty: 64 * MinDifficulty}
+	step := parent.Difficulty / difficultyBoundDivisor
+
+	tests := []struct {
+		name     string
+		elapsed  uint64
+		expected uint64
+	}{
+		{"faster than the target", TargetBlockTime - 1, parent.Difficulty + step},
+		{"on target", TargetBlockTime, parent.Difficulty},
+		{"slower than the target", 3 * TargetBlockTime, parent.Difficulty - 2*step},
+		{"bounded drop", 1000 * TargetBlockTime, parent.Difficulty - maxDifficultyDrop*step},
+	}
+	for _, tc := range tests {
+		if difficulty := NextDifficulty(parent, parent.Time+tc.elapsed); difficulty != tc.expected {
+			t.Fatalf("%s: expected difficulty %d, got %d", tc.name, tc.expected, difficulty)
+		}
+	}
+
+	// The difficulty never drops below the minimum, also for the first block after the fork
+	if difficulty := NextDifficulty(BlockHeader{Time: 1000, Difficulty: MinDifficulty}, 5000); difficulty != MinDifficulty {
+		t.Fatalf("expected the minimum difficulty %d, got %d", MinDifficulty, difficulty)
+	}
+	if difficulty := NextDifficulty(BlockHeader{Time: 1000}, 1000); difficulty != MinDifficulty+MinDifficulty/difficultyBoundDivisor {
+		t.Fatalf("expected the difficulty of a pre-fork parent to start from %d, got %d", MinDifficulty, difficulty)
+	}
+}
+
+func TestStateEnforcesDifficulty(t *testing.T) {
+	dataDir := setupTestDataDir(t, nil)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	_, err = state.AddBlock(mineTestBlock(t, state, miner))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(t, state, miner)
+	block.Header.Difficulty = MinDifficulty / 2
+	_, err = state.AddBlock(block)
+	if err == nil || !strings.Contains(err.Error(), "difficulty") {
+		t.Fatalf("a block with a lower difficulty must be rejected, got error: %v", err)
+	}
+
+	// Nor kept as a side block, whatever its parent
+	block.Header.Difficulty = 1
+	block.Header.Height += 5
+	_, err = state.ImportBlock(block)
+	if err == nil || !strings.Contains(err.Error(), "below the minimum") {
+		t.Fatalf("a side block below the minimum difficulty must be rejected, got error: %v", err)
+	}
+	block.Header.Height -= 5
+
+	// A block coming right after its parent requires a higher difficulty
+	block.Header.Time = state.LatestBlock().Header.Time + 1
+	target := DifficultyTarget(MinDifficulty)
+	for nonce := uint32(0); ; nonce++ {
+		block.Header.Nonce = nonce
+		block.Header.Difficulty = state.NextDifficulty(block.Header.Miner, block.Header.Time)
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A valid hash for the minimum difficulty that doesn't meet the required difficulty
+		if !IsBlockHashValid(hash, target) || block.Header.IsHashValid(hash) {
+			continue
+		}
+		_, err = state.AddBlock(block)
+		if err == nil || !strings.Contains(err.Error(), "invalid block hash") {
+			t.Fatalf("a block hash above the target must be rejected, got error: %v", err)
+		}
+		return
+	}
+}
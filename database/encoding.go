@@ -0,0 +1,238 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Encoding versions of blocks and transactions, see OIP-2.
+//
+// The version selects the canonical encoding used to hash, sign and store a block or a Txn.
+// JSON is only the API representation and has no influence on consensus anymore.
+const (
+	// VersionLegacyJSON encodes with the JSON layout used prior to OIP-2
+	VersionLegacyJSON uint8 = 0
+	// VersionRLP encodes with RLP
+	VersionRLP uint8 = 1
+)
+
+// Frozen JSON layouts of blocks and Txns prior to OIP-2. They must never change,
+// otherwise the hashes of the blocks mined before the fork would change too.
+type legacyTxn struct {
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value legacyAmount   `json:"value"`
+	Nonce uint           `json:"nonce"`
+	Data  string         `json:"data"`
+	Time  uint64         `json:"time"`
+}
+
+type legacyOIP1Txn struct {
+	From     common.Address `json:"from"`
+	To       common.Address `json:"to"`
+	Gas      uint           `json:"gas"`
+	GasPrice legacyAmount   `json:"gasPrice"`
+	Value    legacyAmount   `json:"value"`
+	Nonce    uint           `json:"nonce"`
+	Data     string         `json:"data"`
+	Time     uint64         `json:"time"`
+}
+
+type legacySignedTxn struct {
+	legacyTxn
+	Sig []byte `json:"signature"`
+}
+
+type legacyOIP1SignedTxn struct {
+	legacyOIP1Txn
+	Sig []byte `json:"signature"`
+}
+
+type legacyBlockHeader struct {
+	Height uint64         `json:"height"`
+	Parent Hash           `json:"parent"`
+	Time   uint64         `json:"time"`
+	Nonce  uint32         `json:"nonce"`
+	Miner  common.Address `json:"miner"`
+}
+
+type legacyBlock struct {
+	Header legacyBlockHeader `json:"header"`
+	Txns   []any             `json:"txns"`
+}
+
+func encodeLegacyTxn(t Txn) any {
+	// Prior OIP1 the gas fields were not part of the Txn
+	if t.Gas == 0 {
+		return legacyTxn{t.From, t.To, legacyAmount(t.Value), t.Nonce, t.Data, t.Time}
+	}
+	return legacyOIP1Txn{t.From, t.To, t.Gas, legacyAmount(t.GasPrice), legacyAmount(t.Value), t.Nonce, t.Data, t.Time}
+}
+
+func encodeLegacySignedTxn(s SignedTxn) any {
+	if s.Gas == 0 {
+		return legacySignedTxn{legacyTxn{s.From, s.To, legacyAmount(s.Value), s.Nonce, s.Data, s.Time}, s.Sig}
+	}
+	return legacyOIP1SignedTxn{legacyOIP1Txn{s.From, s.To, s.Gas, legacyAmount(s.GasPrice), legacyAmount(s.Value), s.Nonce, s.Data, s.Time}, s.Sig}
+}
+
+func encodeLegacyBlock(b Block) any {
+	var txns []any
+	if b.Txns != nil {
+		txns = make([]any, len(b.Txns))
+		for i, txn := range b.Txns {
+			txns[i] = encodeLegacySignedTxn(txn)
+		}
+	}
+
+	h := b.Header
+	return legacyBlock{legacyBlockHeader{h.Height, h.Parent, h.Time, h.Nonce, h.Miner}, txns}
+}
+
+// RLP layouts of blocks and Txns since OIP-2
+type rlpTxn struct {
+	Version  uint8
+	From     common.Address
+	To       common.Address
+	Gas      uint
+	GasPrice Amount
+	Value    Amount
+	Nonce    uint
+	Data     string
+	Time     uint64
+	// ChainID is only encoded starting at OIP-10, so the Txns signed before keep their hashes
+	ChainID string `rlp:"optional"`
+}
+
+type rlpSignedTxn struct {
+	Txn rlpTxn
+	Sig []byte
+}
+
+type rlpBlockHeader struct {
+	Version uint8
+	Height  uint64
+	Parent  Hash
+	Time    uint64
+	Nonce   uint32
+	Miner   common.Address
+	// Difficulty is only encoded starting at OIP-3, so the blocks mined before keep their hashes
+	Difficulty uint64 `rlp:"optional"`
+	// TxRoot is only encoded starting at OIP-5
+	TxRoot Hash `rlp:"optional"`
+	// StateRoot is only encoded starting at OIP-6
+	StateRoot Hash `rlp:"optional"`
+	// Seal is only encoded on proof of authority chains
+	Seal []byte `rlp:"optional"`
+}
+
+type rlpBlock struct {
+	Header rlpBlockHeader
+	Txns   []rlpSignedTxn
+}
+
+type rlpBlockFS struct {
+	Key   Hash
+	Value rlpBlock
+}
+
+func toRLPTxn(t Txn) rlpTxn {
+	return rlpTxn{t.Version, t.From, t.To, t.Gas, t.GasPrice, t.Value, t.Nonce, t.Data, t.Time, t.ChainID}
+}
+
+func (r rlpTxn) txn() Txn {
+	return Txn{r.From, r.To, r.Gas, r.GasPrice, r.Value, r.Nonce, r.Data, r.Time, r.Version, r.ChainID}
+}
+
+func toRLPBlockHeader(h BlockHeader) rlpBlockHeader {
+	return rlpBlockHeader{h.Version, h.Height, h.Parent, h.Time, h.Nonce, h.Miner, h.Difficulty, h.TxRoot, h.StateRoot, h.Seal}
+}
+
+func toRLPBlock(b Block) rlpBlock {
+	txns := make([]rlpSignedTxn, len(b.Txns))
+	for i, txn := range b.Txns {
+		txns[i] = rlpSignedTxn{toRLPTxn(txn.Txn), txn.Sig}
+	}
+
+	return rlpBlock{toRLPBlockHeader(b.Header), txns}
+}
+
+func (r rlpBlock) block() Block {
+	txns := make([]SignedTxn, len(r.Txns))
+	for i, txn := range r.Txns {
+		txns[i] = SignedTxn{txn.Txn.txn(), txn.Sig}
+	}
+
+	h := r.Header
+	return Block{BlockHeader{h.Height, h.Parent, h.Time, h.Nonce, h.Miner, h.Version, h.Difficulty, h.TxRoot, h.StateRoot, h.Seal}, txns}
+}
+
+// encodeTxn returns the canonical encoding of a Txn, hashed to identify and sign it
+func encodeTxn(t Txn) ([]byte, error) {
+	switch t.Version {
+	case VersionLegacyJSON:
+		if t.ChainID != "" {
+			return nil, fmt.Errorf("the chain ID of a Txn can't be encoded with version %d", VersionLegacyJSON)
+		}
+		return json.Marshal(encodeLegacyTxn(t))
+	case VersionRLP:
+		return rlp.EncodeToBytes(toRLPTxn(t))
+	default:
+		return nil, fmt.Errorf("unknown Txn version %d", t.Version)
+	}
+}
+
+// encodeSignedTxn returns the canonical encoding of a signed Txn, hashed as a leaf of the TxRoot
+func encodeSignedTxn(t SignedTxn) ([]byte, error) {
+	switch t.Version {
+	case VersionLegacyJSON:
+		if t.ChainID != "" {
+			return nil, fmt.Errorf("the chain ID of a Txn can't be encoded with version %d", VersionLegacyJSON)
+		}
+		return json.Marshal(encodeLegacySignedTxn(t))
+	case VersionRLP:
+		return rlp.EncodeToBytes(rlpSignedTxn{toRLPTxn(t.Txn), t.Sig})
+	default:
+		return nil, fmt.Errorf("unknown Txn version %d", t.Version)
+	}
+}
+
+// encodeBlockHeader returns the canonical encoding of a block header committing to the Txns
+// with a TxRoot, hashed to identify the block since OIP-5
+func encodeBlockHeader(h BlockHeader) ([]byte, error) {
+	if h.Version != VersionRLP {
+		return nil, fmt.Errorf("the header of a block can't be encoded alone with version %d", h.Version)
+	}
+	return rlp.EncodeToBytes(toRLPBlockHeader(h))
+}
+
+// encodeBlock returns the canonical encoding of a block, hashed to identify it
+func encodeBlock(b Block) ([]byte, error) {
+	switch b.Header.Version {
+	case VersionLegacyJSON:
+		if b.Header.Difficulty != 0 {
+			return nil, fmt.Errorf("the difficulty of a block can't be encoded with version %d", VersionLegacyJSON)
+		}
+		if !b.Header.TxRoot.IsEmpty() {
+			return nil, fmt.Errorf("the Txn root of a block can't be encoded with version %d", VersionLegacyJSON)
+		}
+		if !b.Header.StateRoot.IsEmpty() {
+			return nil, fmt.Errorf("the state root of a block can't be encoded with version %d", VersionLegacyJSON)
+		}
+		if len(b.Header.Seal) > 0 {
+			return nil, fmt.Errorf("the seal of a block can't be encoded with version %d", VersionLegacyJSON)
+		}
+		for _, txn := range b.Txns {
+			if txn.ChainID != "" {
+				return nil, fmt.Errorf("the chain ID of a Txn can't be encoded with version %d", VersionLegacyJSON)
+			}
+		}
+		return json.Marshal(encodeLegacyBlock(b))
+	case VersionRLP:
+		return rlp.EncodeToBytes(toRLPBlock(b))
+	default:
+		return nil, fmt.Errorf("unknown block version %d", b.Header.Version)
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testLegacyTxns() (Txn, Txn) {
+	from := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+	to := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	legacy := Txn{from, to, 0, Amount{}, NewAmount(5), 1, "", 1650000000, VersionLegacyJSON, ""}
+	oip1 := Txn{from, to, 10, NewAmount(1), NewAmount(7), 2, "hi", 1650000001, VersionLegacyJSON, ""}
+	return legacy, oip1
+}
+
+// The hashes were computed from the JSON encoding used before OIP-2 and must never change
+func TestLegacyHashes(t *testing.T) {
+	legacy, oip1 := testLegacyTxns()
+	to := oip1.To
+
+	block := NewBlock(3, Hash{1, 2}, 1650000002, 42, to, []SignedTxn{
+		NewSignedTxn(legacy, []byte{1, 2, 3}),
+		NewSignedTxn(oip1, []byte{4, 5, 6}),
+	})
+	block.Header.Version = VersionLegacyJSON
+
+	emptyBlock := NewBlock(0, Hash{}, 1650000003, 7, to, nil)
+	emptyBlock.Header.Version = VersionLegacyJSON
+
+	cases := []struct {
+		name     string
+		hash     func() (Hash, error)
+		expected string
+	}{
+		{"LegacyTxn", legacy.Hash, "e74b917fce05c97d726570f78bf509d6b7c05f56372a7cd68271ba13a1c9fa98"},
+		{"OIP1Txn", oip1.Hash, "19f6653e030306778a67b3ebb25af20fbc7c2115a022b7cd0347228f5b077f5f"},
+		{"Block", block.Hash, "eccbd52a535411aed137705095c3d13cba908787aa3573eb9b8cd7e3f344c375"},
+		{"EmptyBlock", emptyBlock.Hash, "a0defa2978c4157f0c564b386fc5ec51b7e74db4528c3282a6804a8c0188ed5c"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hash, err := c.hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash.Hex() != c.expected {
+				t.Fatalf("expected hash %s, got %s", c.expected, hash.Hex())
+			}
+		})
+	}
+}
+
+func TestRLPEncoding(t *testing.T) {
+	legacy, oip1 := testLegacyTxns()
+	legacy.Version = VersionRLP
+	oip1.Version = VersionRLP
+
+	block := NewBlock(3, Hash{1, 2}, 1650000002, 42, oip1.To, []SignedTxn{
+		NewSignedTxn(legacy, []byte{1, 2, 3}),
+		NewSignedTxn(oip1, []byte{4, 5, 6}),
+	})
+	block.Header.Version = VersionRLP
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The API JSON has no influence on the hash
+	blockJson, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Block
+	err = json.Unmarshal(blockJson, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedHash, err := decoded.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedHash != hash {
+		t.Fatal("the block hash changed after a JSON round trip")
+	}
+
+	// The version is part of the hash
+	legacyHash, err := legacy.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Version = VersionLegacyJSON
+	legacyJSONHash, err := legacy.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacyHash == legacyJSONHash {
+		t.Fatal("Txns encoded with different versions must have different hashes")
+	}
+
+	// Stored as a RLP record
+	record, err := encodeRecord(BlockFS{hash, block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record[0] != recordCodecRLP {
+		t.Fatalf("expected a '%c' record, got '%c'", recordCodecRLP, record[0])
+	}
+	blockFs, _, err := readRecord(bytes.NewReader(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedHash, err := blockFs.Value.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockFs.Key != hash || storedHash != hash {
+		t.Fatal("the stored block doesn't match the original block")
+	}
+}
+
+func TestStateEnforcesEncodingVersion(t *testing.T) {
+	dataDir := t.TempDir()
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.EncodingVersion() != VersionLegacyJSON {
+		t.Fatal("OIP-2 should not be active at genesis of the default chain")
+	}
+
+	for nonce := uint32(0); ; nonce++ {
+		block := NewBlock(0, Hash{}, uint64(nonce), nonce, miner, []SignedTxn{})
+		block.Header.Version = VersionRLP
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !block.Header.IsHashValid(hash) {
+			continue
+		}
+
+		_, err = state.AddBlock(block)
+		if err == nil || !strings.Contains(err.Error(), "encoding version") {
+			t.Fatalf("a RLP block must be rejected before OIP-2, got error: %v", err)
+		}
+		return
+	}
+}
@@ -17,6 +17,22 @@ func getBlocksDbFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), "blocks.db")
 }
 
+func getBlocksDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "blocks")
+}
+
+func getBlocksLevelDBDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "blocks.ldb")
+}
+
+func getIndexesDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "indexes")
+}
+
+func getSnapshotsDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "snapshots")
+}
+
 func writeEmptyBlocksDbToDisk(path string) error {
 	return os.WriteFile(path, []byte(""), os.ModePerm)
 }
@@ -7,9 +7,23 @@ import (
 )
 
 type Genesis struct {
-	Balances map[common.Address]uint `json:"balances"`
-	Symbol   string                  `json:"symbol"`
-	ForkOIP1 uint64                  `json:"fork_oip_1"`
+	Balances map[common.Address]Amount `json:"balances"`
+	Symbol   string                    `json:"symbol"`
+	// ChainID identifies the chain in the signed Txns since OIP-10
+	ChainID string `json:"chain_id"`
+	// Forks maps the OIPs to their activation heights, the fork_<name> keys of genesis.json.
+	// The OIPs missing from it get the height they had before they were introduced, OIP-1 activates
+	// at height 0 and the later OIPs stay disabled.
+	Forks map[string]uint64 `json:"-"`
+
+	// RewardSchedule defaults to Reward per block forever
+	RewardSchedule *RewardSchedule `json:"reward_schedule,omitempty"`
+	// BlockLimits defaults to DefaultBlockGasLimit and DefaultMaxBlockSize
+	BlockLimits *BlockLimits `json:"block_limits,omitempty"`
+	// Consensus defaults to the proof of work
+	Consensus *ConsensusConfig `json:"consensus,omitempty"`
+	// Checkpoints pin the hashes of blocks of the chain, sorted by increasing height
+	Checkpoints []Checkpoint `json:"checkpoints,omitempty"`
 }
 
 var genesisJson = `
@@ -38,6 +52,33 @@ func loadGenesis(path string) (Genesis, error) {
 	return loadedGenesis, nil
 }
 
+// genesisFields is the Genesis without its JSON methods
+type genesisFields Genesis
+
+func (g Genesis) MarshalJSON() ([]byte, error) {
+	content, err := json.Marshal(genesisFields(g))
+	if err != nil {
+		return nil, err
+	}
+	return writeForks(content, g.Forks)
+}
+
+// UnmarshalJSON reads the fork_<name> keys into Forks, the forks missing from a genesis.json
+// written before they were introduced stay disabled
+func (g *Genesis) UnmarshalJSON(data []byte) error {
+	var fields genesisFields
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	fields.Forks, err = readForks(data)
+	if err != nil {
+		return err
+	}
+	*g = Genesis(fields)
+	return nil
+}
+
 func writeGenesisToDisk(path string, genesis []byte) error {
 	return os.WriteFile(path, genesis, 0644)
 }
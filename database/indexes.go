@@ -0,0 +1,346 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"log"
+	"math"
+)
+
+// indexesVersion changes whenever new indexes are added, the indexes are then rebuilt from the block store
+const indexesVersion = 2
+
+var (
+	indexVersionKey    = []byte("version") // version -> big endian indexesVersion
+	indexTipKey        = []byte("tip")     // tip -> big endian height of the last indexed block
+	indexTxnPrefix     = []byte("t")       // t + txn hash -> big endian height + position in the block
+	indexAccountPrefix = []byte("a")       // a + address + big endian height + sequence in the block -> AccountTxn json
+)
+
+const (
+	AccountTxnTransfer = "transfer"
+	AccountTxnReward   = "reward"
+	AccountTxnFees     = "fees"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+	DirectionAll = "all"
+
+	DefaultAccountTxnsLimit = 50
+	MaxAccountTxnsLimit     = 500
+)
+
+// AccountTxn is an entry of the history of an account. Besides the transfers, the block
+// reward and the Txn fees credited to a miner are listed so the balance can be reconciled.
+type AccountTxn struct {
+	Kind      string         `json:"kind"`
+	Direction string         `json:"direction"`
+	Height    uint64         `json:"block_height"`
+	BlockHash Hash           `json:"block_hash"`
+	TxnHash   *Hash          `json:"txn_hash,omitempty"`
+	From      common.Address `json:"from"`
+	To        common.Address `json:"to"`
+	Value     Amount         `json:"value"`
+	Fee       Amount         `json:"fee"`
+}
+
+// chainIndexes keeps the lookup indexes of the mined blocks in leveldb.
+// The indexes are derived from the block store, blocks missing from
+// them are indexed again from the store on the next start.
+type chainIndexes struct {
+	db          *leveldb.DB
+	chainConfig ChainConfig
+	rewards     blockRewards
+}
+
+// txnLocation locates a mined Txn in the chain
+type txnLocation struct {
+	Height uint64
+	Index  uint32
+}
+
+func openChainIndexes(path string, chainConfig ChainConfig, rewards blockRewards) (*chainIndexes, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := &chainIndexes{db, chainConfig, rewards}
+	err = ci.resetIfOutdated()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ci, nil
+}
+
+// resetIfOutdated drops indexes written by another version so they are rebuilt with catchUp
+func (ci *chainIndexes) resetIfOutdated() error {
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, indexesVersion)
+
+	current, err := ci.db.Get(indexVersionKey, nil)
+	if err == nil && bytes.Equal(current, version) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	iter := ci.db.NewIterator(nil, nil)
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err = iter.Error(); err != nil {
+		return err
+	}
+
+	if batch.Len() > 0 {
+		log.Printf("Rebuilding the chain indexes for version %d\n", indexesVersion)
+	}
+	batch.Put(indexVersionKey, version)
+	return ci.db.Write(batch, nil)
+}
+
+func indexTxnKey(hash Hash) []byte {
+	return append(append([]byte{}, indexTxnPrefix...), hash[:]...)
+}
+
+func indexAccountPrefixKey(account common.Address) []byte {
+	return append(append([]byte{}, indexAccountPrefix...), account[:]...)
+}
+
+func indexAccountKey(account common.Address, height uint64, seq uint32) []byte {
+	position := make([]byte, 8+4)
+	binary.BigEndian.PutUint64(position[0:8], height)
+	binary.BigEndian.PutUint32(position[8:12], seq)
+	return append(indexAccountPrefixKey(account), position...)
+}
+
+func encodeTxnLocation(l txnLocation) []byte {
+	value := make([]byte, 8+4)
+	binary.BigEndian.PutUint64(value[0:8], l.Height)
+	binary.BigEndian.PutUint32(value[8:12], l.Index)
+	return value
+}
+
+// tip returns the height of the last indexed block, ok is false when nothing was indexed yet
+func (ci *chainIndexes) tip() (height uint64, ok bool, err error) {
+	value, err := ci.db.Get(indexTipKey, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(value), true, nil
+}
+
+// indexBlock adds the Txns of the block to the indexes in a single write
+func (ci *chainIndexes) indexBlock(blockFs BlockFS) error {
+	batch := new(leveldb.Batch)
+	err := ci.blockEntries(blockFs, batch.Put)
+	if err != nil {
+		return err
+	}
+
+	tip := make([]byte, 8)
+	binary.BigEndian.PutUint64(tip, blockFs.Value.Header.Height)
+	batch.Put(indexTipKey, tip)
+
+	return ci.db.Write(batch, nil)
+}
+
+// unindexBlocks removes the blocks at the tip of the chain, starting at the height from, in a single write
+func (ci *chainIndexes) unindexBlocks(blocks []BlockFS, from uint64) error {
+	batch := new(leveldb.Batch)
+	for _, blockFs := range blocks {
+		err := ci.blockEntries(blockFs, func(key, _ []byte) {
+			batch.Delete(key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if from == 0 {
+		batch.Delete(indexTipKey)
+	} else {
+		tip := make([]byte, 8)
+		binary.BigEndian.PutUint64(tip, from-1)
+		batch.Put(indexTipKey, tip)
+	}
+
+	return ci.db.Write(batch, nil)
+}
+
+// blockEntries calls put with every index entry of the block
+func (ci *chainIndexes) blockEntries(blockFs BlockFS, put func(key, value []byte)) error {
+	b := blockFs.Value
+	height := b.Header.Height
+	rules := ci.chainConfig.Rules(height)
+
+	seq := uint32(0)
+	putAccountTxn := func(account common.Address, entry AccountTxn) error {
+		entryJson, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		put(indexAccountKey(account, height, seq), entryJson)
+		seq++
+		return nil
+	}
+
+	// Since OIP-11 the reward and the fees are credited by the coinbase Txn, the first of the block
+	coinbase, _ := splitCoinbase(b.Txns, rules)
+	var coinbaseHash *Hash
+
+	for i, txn := range b.Txns {
+		txnHash, err := txn.Hash()
+		if err != nil {
+			return err
+		}
+		put(indexTxnKey(txnHash), encodeTxnLocation(txnLocation{height, uint32(i)}))
+
+		if coinbase != nil && i == 0 {
+			coinbaseHash = &txnHash
+			continue
+		}
+
+		cost, err := txn.TotalCost(rules)
+		if err != nil {
+			return err
+		}
+		fee, err := cost.Sub(txn.Value)
+		if err != nil {
+			return err
+		}
+		out := AccountTxn{AccountTxnTransfer, DirectionOut, height, blockFs.Key, &txnHash, txn.From, txn.To, txn.Value, fee}
+		if err = putAccountTxn(txn.From, out); err != nil {
+			return err
+		}
+
+		in := AccountTxn{AccountTxnTransfer, DirectionIn, height, blockFs.Key, &txnHash, txn.From, txn.To, txn.Value, Amount{}}
+		if err = putAccountTxn(txn.To, in); err != nil {
+			return err
+		}
+	}
+
+	miner := b.Header.Miner
+	reward, err := ci.rewards.reward(height)
+	if err != nil {
+		return err
+	}
+	// The blocks mined once the max supply is reached pay no reward
+	if !reward.IsZero() {
+		entry := AccountTxn{AccountTxnReward, DirectionIn, height, blockFs.Key, coinbaseHash, common.Address{}, miner, reward, Amount{}}
+		if err := putAccountTxn(miner, entry); err != nil {
+			return err
+		}
+	}
+	fees, err := b.Fees(rules)
+	if err != nil {
+		return err
+	}
+	if !fees.IsZero() {
+		entry := AccountTxn{AccountTxnFees, DirectionIn, height, blockFs.Key, coinbaseHash, common.Address{}, miner, fees, Amount{}}
+		if err := putAccountTxn(miner, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUp indexes the stored blocks above the tip of the indexes
+func (ci *chainIndexes) catchUp(store BlockStore) error {
+	tip, ok, err := ci.tip()
+	if err != nil {
+		return err
+	}
+
+	from := uint64(0)
+	if ok {
+		from = tip + 1
+	}
+
+	return store.Iterate(from, math.MaxUint64, ci.indexBlock)
+}
+
+func (ci *chainIndexes) getTxnLocation(hash Hash) (txnLocation, error) {
+	value, err := ci.db.Get(indexTxnKey(hash), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return txnLocation{}, fmt.Errorf("unknown Txn: %s", hash.Hex())
+	}
+	if err != nil {
+		return txnLocation{}, err
+	}
+
+	return txnLocation{binary.BigEndian.Uint64(value[0:8]), binary.BigEndian.Uint32(value[8:12])}, nil
+}
+
+// getAccountTxns returns up to limit entries of the account history in ascending height order,
+// starting at the cursor. The returned cursor points to the next entry, it's empty on the last page.
+// The entries of the blocks below the height from are skipped.
+func (ci *chainIndexes) getAccountTxns(account common.Address, from uint64, cursor string, limit int, direction string) ([]AccountTxn, string, error) {
+	switch direction {
+	case DirectionIn, DirectionOut, DirectionAll:
+	case "":
+		direction = DirectionAll
+	default:
+		return nil, "", fmt.Errorf("unknown direction '%s', expected %s, %s or %s", direction, DirectionIn, DirectionOut, DirectionAll)
+	}
+
+	if limit <= 0 {
+		limit = DefaultAccountTxnsLimit
+	}
+	if limit > MaxAccountTxnsLimit {
+		limit = MaxAccountTxnsLimit
+	}
+
+	prefix := indexAccountPrefixKey(account)
+	accountRange := util.BytesPrefix(prefix)
+	if cursor != "" {
+		position, err := hex.DecodeString(cursor)
+		if err != nil || len(position) != 8+4 {
+			return nil, "", fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		accountRange.Start = append(prefix, position...)
+	}
+	if fromKey := indexAccountKey(account, from, 0); bytes.Compare(accountRange.Start, fromKey) < 0 {
+		accountRange.Start = fromKey
+	}
+
+	iter := ci.db.NewIterator(accountRange, nil)
+	defer iter.Release()
+
+	entries := make([]AccountTxn, 0)
+	for iter.Next() {
+		var entry AccountTxn
+		err := json.Unmarshal(iter.Value(), &entry)
+		if err != nil {
+			return nil, "", err
+		}
+		if direction != DirectionAll && entry.Direction != direction {
+			continue
+		}
+
+		if len(entries) == limit {
+			return entries, hex.EncodeToString(iter.Key()[len(prefix):]), nil
+		}
+		entries = append(entries, entry)
+	}
+	return entries, "", iter.Error()
+}
+
+func (ci *chainIndexes) Close() error {
+	return ci.db.Close()
+}
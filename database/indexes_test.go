@@ -0,0 +1,208 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"os"
+	"testing"
+)
+
+func TestStateTxnIndex(t *testing.T) {
+	key, sender := newTestAccount(t)
+	receiver := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	dataDir := setupTestDataDir(t, map[common.Address]Amount{sender: NewAmount(1000)})
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn1 := newTestTxn(sender, receiver, NewAmount(10), 1, "")
+	txn2 := newTestTxn(sender, receiver, NewAmount(20), 2, "")
+	txn2.Time = txn1.Time + 1
+
+	_, err = state.AddBlock(mineTestBlock(t, state, receiver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockHash, err := state.AddBlock(mineTestBlock(t, state, receiver, signTestTxn(t, txn1, key), signTestTxn(t, txn2, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn2Hash, err := txn2.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMinedTxn := func(state *State) {
+		minedTxn, err := state.GetMinedTxn(txn2Hash.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if minedTxn.BlockHash != blockHash || minedTxn.Height != 1 || minedTxn.Index != 2 || minedTxn.Txn.Value != NewAmount(20) {
+			t.Fatalf("unexpected mined Txn %+v", minedTxn)
+		}
+	}
+	assertMinedTxn(state)
+
+	if _, err = state.GetMinedTxn(Hash{1}.Hex()); err == nil {
+		t.Fatal("looking up an unknown Txn should fail")
+	}
+	state.Close()
+
+	// The indexes are rebuilt from the block store
+	err = os.RemoveAll(getIndexesDirPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	assertMinedTxn(state)
+}
+
+func TestStateAccountTxnsIndex(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	genesisBalances := map[common.Address]Amount{sender: NewAmount(1000)}
+	dataDir := setupTestDataDir(t, genesisBalances)
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	txn1 := newTestTxn(sender, miner, NewAmount(10), 1, "")
+	txn2 := newTestTxn(sender, miner, NewAmount(20), 2, "")
+	txn2.Time = txn1.Time + 1
+
+	_, err = state.AddBlock(mineTestBlock(t, state, miner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = state.AddBlock(mineTestBlock(t, state, miner, signTestTxn(t, txn1, key), signTestTxn(t, txn2, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The balances can be reconciled from the history
+	for _, account := range []common.Address{sender, miner} {
+		entries, cursor, err := state.GetAccountTxns(account, "", MaxAccountTxnsLimit, DirectionAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor != "" {
+			t.Fatal("all the entries should fit in a single page")
+		}
+
+		balance := genesisBalances[account]
+		for _, entry := range entries {
+			if entry.Direction == DirectionIn {
+				balance, err = balance.Add(entry.Value)
+			} else {
+				balance, err = balance.Sub(entry.Value)
+				if err == nil {
+					balance, err = balance.Sub(entry.Fee)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if balance != state.Balances[account] {
+			t.Fatalf("balance of %s reconciled from %d entries is %s, expected %s", account, len(entries), balance, state.Balances[account])
+		}
+	}
+
+	// 2 rewards, 2 transfers and the fees of the 2nd block
+	var kinds []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		entries, next, err := state.GetAccountTxns(miner, cursor, 2, DirectionIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			kinds = append(kinds, entry.Kind)
+		}
+		cursor = next
+	}
+	expected := []string{AccountTxnReward, AccountTxnTransfer, AccountTxnTransfer, AccountTxnReward, AccountTxnFees}
+	if cursor != "" || len(kinds) != len(expected) {
+		t.Fatalf("expected the entries %v, got %v", expected, kinds)
+	}
+	for i := range expected {
+		if kinds[i] != expected[i] {
+			t.Fatalf("expected the entries %v, got %v", expected, kinds)
+		}
+	}
+
+	entries, _, err := state.GetAccountTxns(miner, "", 0, DirectionOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("the miner didn't send anything, got %d out entries", len(entries))
+	}
+}
+
+// testForks returns the forks of the test chains, every OIP active from height 0 unless overridden
+func testForks(overrides map[string]uint64) map[string]uint64 {
+	forks := make(map[string]uint64)
+	for _, oip := range oips {
+		forks[oip.name] = 0
+	}
+	for name, height := range overrides {
+		forks[name] = height
+	}
+	return forks
+}
+
+// setupTestDataDir initializes a data dir with all the forks active from the genesis
+func setupTestDataDir(t *testing.T, balances map[common.Address]Amount) string {
+	dataDir := t.TempDir()
+
+	genesis, err := json.Marshal(Genesis{Balances: balances, Symbol: "OPB", Forks: testForks(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataDir
+}
+
+func newTestAccount(t *testing.T) (*ecdsa.PrivateKey, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+// newTestTxn returns a default Txn encoded with RLP, the test chains activate OIP-2 at height 0
+func newTestTxn(from, to common.Address, value Amount, nonce uint, data string) Txn {
+	txn := NewDefaultTxn(from, to, value, nonce, data)
+	txn.Version = VersionRLP
+	return txn
+}
+
+func signTestTxn(t *testing.T, txn Txn, key *ecdsa.PrivateKey) SignedTxn {
+	txnHash, err := txn.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := crypto.Sign(txnHash[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSignedTxn(txn, sig)
+}
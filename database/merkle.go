@@ -0,0 +1,163 @@
+package database
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Prefixes of the hashed Merkle tree nodes, see OIP-5. They keep a leaf from being
+// passed off as an inner node and the other way around.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// emptyTxRoot is the TxRoot of a block without Txns
+var emptyTxRoot = Hash(sha256.Sum256(nil))
+
+// MerkleProofStep is a sibling hashed with the path from a Txn leaf to the TxRoot
+type MerkleProofStep struct {
+	Hash Hash `json:"hash"`
+	// Left tells if the sibling is on the left of the path
+	Left bool `json:"left"`
+}
+
+// TxnProof proves that a Txn is part of a block. It's verified with the block header alone,
+// the header hash is the block hash since OIP-5.
+type TxnProof struct {
+	Txn       SignedTxn         `json:"txn"`
+	BlockHash Hash              `json:"block_hash"`
+	Header    BlockHeader       `json:"header"`
+	Index     uint32            `json:"index"`
+	Proof     []MerkleProofStep `json:"proof"`
+}
+
+func merkleLeaf(encodedTxn []byte) Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, encodedTxn...))
+}
+
+func merkleNode(left, right Hash) Hash {
+	data := make([]byte, 0, 1+2*len(left))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// txnLeaves returns the Merkle leaves of the signed Txns, in block order
+func txnLeaves(txns []SignedTxn) ([]Hash, error) {
+	leaves := make([]Hash, len(txns))
+	for i, txn := range txns {
+		encoded, err := encodeSignedTxn(txn)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = merkleLeaf(encoded)
+	}
+	return leaves, nil
+}
+
+// nextMerkleLevel hashes the nodes of a level by pairs. The last node of an odd level
+// moves up unchanged, it's never paired with itself.
+func nextMerkleLevel(level []Hash) []Hash {
+	next := make([]Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+// ComputeTxRoot returns the Merkle root of the signed Txns in block order
+func ComputeTxRoot(txns []SignedTxn) (Hash, error) {
+	if len(txns) == 0 {
+		return emptyTxRoot, nil
+	}
+
+	level, err := txnLeaves(txns)
+	if err != nil {
+		return Hash{}, err
+	}
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0], nil
+}
+
+// merkleProof returns the siblings on the path from the leaf at the index to the root
+func merkleProof(leaves []Hash, index int) []MerkleProofStep {
+	proof := make([]MerkleProofStep, 0)
+	level := leaves
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, MerkleProofStep{level[index-1], true})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleProofStep{level[index+1], false})
+		}
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyTxnProof verifies that the Txn of the proof is part of the block with the proof header.
+// The caller is left to check that the block hash belongs to the chain it follows.
+func VerifyTxnProof(p TxnProof) error {
+	if p.Header.TxRoot.IsEmpty() {
+		return fmt.Errorf("block at height %d has no Txn root", p.Header.Height)
+	}
+
+	hash, err := p.Header.Hash()
+	if err != nil {
+		return err
+	}
+	if hash != p.BlockHash {
+		return fmt.Errorf("the header hash %s doesn't match the block hash %s", hash.Hex(), p.BlockHash.Hex())
+	}
+
+	encoded, err := encodeSignedTxn(p.Txn)
+	if err != nil {
+		return err
+	}
+	node := merkleLeaf(encoded)
+	for _, step := range p.Proof {
+		if step.Left {
+			node = merkleNode(step.Hash, node)
+		} else {
+			node = merkleNode(node, step.Hash)
+		}
+	}
+
+	if node != p.Header.TxRoot {
+		return fmt.Errorf("the Txn isn't part of block %s", p.BlockHash.Hex())
+	}
+	return nil
+}
+
+// GetTxnProof returns the Merkle inclusion proof of a mined Txn. Only the blocks mined
+// since OIP-5 commit to their Txns with a TxRoot.
+func (s *State) GetTxnProof(hash string) (TxnProof, error) {
+	minedTxn, err := s.GetMinedTxn(hash)
+	if err != nil {
+		return TxnProof{}, err
+	}
+
+	blockFs, err := s.store.GetByHeight(minedTxn.Height)
+	if err != nil {
+		return TxnProof{}, err
+	}
+	if blockFs.Value.Header.TxRoot.IsEmpty() {
+		return TxnProof{}, fmt.Errorf("block at height %d was mined before OIP-5 and has no Txn root", minedTxn.Height)
+	}
+
+	leaves, err := txnLeaves(blockFs.Value.Txns)
+	if err != nil {
+		return TxnProof{}, err
+	}
+
+	proof := merkleProof(leaves, int(minedTxn.Index))
+	return TxnProof{minedTxn.Txn, blockFs.Key, blockFs.Value.Header, minedTxn.Index, proof}, nil
+}
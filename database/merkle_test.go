@@ -0,0 +1,113 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestTxnProofs(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	dataDir := setupTestDataDir(t, map[common.Address]Amount{sender: NewAmount(1000)})
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	// An odd count of Txns with the coinbase, the last one moves up the tree unpaired
+	var txns []SignedTxn
+	for nonce := uint(1); nonce <= 4; nonce++ {
+		txns = append(txns, signTestTxn(t, newTestTxn(sender, miner, NewAmount(10), nonce, ""), key))
+	}
+	block := mineTestBlock(t, state, miner, txns...)
+	blockHash, err := state.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headerHash, err := block.Header.Hash(); err != nil || headerHash != blockHash {
+		t.Fatalf("expected the block hash %s to be the header hash, got %s: %v", blockHash.Hex(), headerHash.Hex(), err)
+	}
+
+	for i, txn := range block.Txns {
+		proof, err := state.GetTxnProof(mustTxnHash(t, txn.Txn).Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Index != uint32(i) || proof.BlockHash != blockHash {
+			t.Fatalf("expected Txn %d of block %s, got %d of %s", i, blockHash.Hex(), proof.Index, proof.BlockHash.Hex())
+		}
+
+		// The proof goes through the API as JSON
+		proofJson, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received TxnProof
+		err = json.Unmarshal(proofJson, &received)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyTxnProof(received); err != nil {
+			t.Fatalf("the proof of Txn %d should be valid: %s", i, err)
+		}
+
+		forged := received
+		forged.Txn.Value = NewAmount(11)
+		if err = VerifyTxnProof(forged); err == nil {
+			t.Fatalf("the proof of a changed Txn %d must be rejected", i)
+		}
+		forged = received
+		forged.Header.Nonce++
+		if err = VerifyTxnProof(forged); err == nil {
+			t.Fatalf("the proof of Txn %d with a changed header must be rejected", i)
+		}
+	}
+
+	// The Txns of a block must match its TxRoot
+	tampered := mineTestBlock(t, state, miner, signTestTxn(t, newTestTxn(sender, miner, NewAmount(10), 6, ""), key))
+	tampered.Txns = append(tampered.Txns, signTestTxn(t, newTestTxn(sender, miner, NewAmount(10), 7, ""), key))
+	_, err = state.AddBlock(tampered)
+	if err == nil || !strings.Contains(err.Error(), "Txn root") {
+		t.Fatalf("a block with Txns not matching its Txn root must be rejected, got error: %v", err)
+	}
+}
+
+func TestTxRootBeforeOIP5(t *testing.T) {
+	dataDir := t.TempDir()
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	genesis, err := json.Marshal(Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", Forks: testForks(map[string]uint64{OIP5: ForkDisabled, OIP6: ForkDisabled})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	txn := newTestTxn(sender, miner, NewAmount(10), 1, "")
+	block := mineTestBlock(t, state, miner, signTestTxn(t, txn, key))
+	if !block.Header.TxRoot.IsEmpty() {
+		t.Fatal("blocks prior to the fork have no Txn root")
+	}
+	_, err = state.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = state.GetTxnProof(mustTxnHash(t, txn).Hex())
+	if err == nil || !strings.Contains(err.Error(), "no Txn root") {
+		t.Fatalf("expected no proof for a Txn mined before the fork, got error: %v", err)
+	}
+}
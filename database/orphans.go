@@ -0,0 +1,131 @@
+package database
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxOrphanBlocks is the number of orphan blocks kept, the oldest one is evicted for a new one beyond it
+	MaxOrphanBlocks = 100
+	// MaxOrphanAge is how long an orphan block waits for its parent before it's forgotten
+	MaxOrphanAge = 10 * time.Minute
+)
+
+// orphanBlock is a block received before its parent
+type orphanBlock struct {
+	block    Block
+	received time.Time
+}
+
+// orphanPool holds the blocks whose parent is unknown, by parent hash, until their parent is imported
+type orphanPool struct {
+	byParent map[Hash]map[Hash]orphanBlock
+	count    int
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{make(map[Hash]map[Hash]orphanBlock), 0}
+}
+
+// add keeps the orphan block, evicting the expired orphans and the oldest one when the pool is full
+func (p *orphanPool) add(hash Hash, b Block, now time.Time) {
+	p.expire(now)
+
+	parent := b.Header.Parent
+	if _, ok := p.byParent[parent][hash]; ok {
+		return
+	}
+	if p.count >= MaxOrphanBlocks {
+		p.evictOldest()
+	}
+
+	if p.byParent[parent] == nil {
+		p.byParent[parent] = make(map[Hash]orphanBlock)
+	}
+	p.byParent[parent][hash] = orphanBlock{b, now}
+	p.count++
+}
+
+// take removes and returns the orphans of the parent, oldest first
+func (p *orphanPool) take(parent Hash, now time.Time) []Block {
+	p.expire(now)
+
+	orphans := make([]orphanBlock, 0, len(p.byParent[parent]))
+	for _, orphan := range p.byParent[parent] {
+		orphans = append(orphans, orphan)
+	}
+	p.count -= len(orphans)
+	delete(p.byParent, parent)
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].received.Before(orphans[j].received)
+	})
+	blocks := make([]Block, len(orphans))
+	for i, orphan := range orphans {
+		blocks[i] = orphan.block
+	}
+	return blocks
+}
+
+func (p *orphanPool) remove(parent, hash Hash) {
+	delete(p.byParent[parent], hash)
+	if len(p.byParent[parent]) == 0 {
+		delete(p.byParent, parent)
+	}
+	p.count--
+}
+
+func (p *orphanPool) expire(now time.Time) {
+	for parent, orphans := range p.byParent {
+		for hash, orphan := range orphans {
+			if now.Sub(orphan.received) > MaxOrphanAge {
+				p.remove(parent, hash)
+			}
+		}
+	}
+}
+
+func (p *orphanPool) evictOldest() {
+	var oldest orphanBlock
+	var oldestHash Hash
+	found := false
+	for _, orphans := range p.byParent {
+		for hash, orphan := range orphans {
+			if !found || orphan.received.Before(oldest.received) {
+				oldest, oldestHash, found = orphan, hash, true
+			}
+		}
+	}
+	if found {
+		p.remove(oldest.block.Header.Parent, oldestHash)
+	}
+}
+
+// OrphanCount returns the number of blocks waiting for their parent
+func (s *State) OrphanCount() int {
+	return s.orphans.count
+}
+
+// connectOrphans imports the orphans waiting for the block with the hash, then their own orphans
+func (s *State) connectOrphans(parent Hash) []ImportResult {
+	var results []ImportResult
+	parents := []Hash{parent}
+	for len(parents) > 0 {
+		parent, parents = parents[0], parents[1:]
+
+		for _, orphan := range s.orphans.take(parent, s.clock.Now()) {
+			result, err := s.importBlock(orphan)
+			if err != nil {
+				log.Printf("ERROR: unable to connect the orphan block at height %d: %s\n", orphan.Header.Height, err)
+				continue
+			}
+			results = append(results, result)
+			if result.Status != BlockKnown && result.Status != BlockOrphan {
+				parents = append(parents, result.Hash)
+			}
+		}
+	}
+	return results
+}
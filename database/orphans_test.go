@@ -0,0 +1,98 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateConnectsOrphans(t *testing.T) {
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	source, err := NewStateFromDisk(setupTestDataDir(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	var blocks []Block
+	for i := 0; i < 4; i++ {
+		block := mineTestBlock(t, source, miner)
+		if _, err = source.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	state, err := NewStateFromDisk(setupTestDataDir(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	// Blocks received before their parent wait for it
+	for _, block := range []Block{blocks[3], blocks[2]} {
+		result, err := state.ImportBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != BlockOrphan {
+			t.Fatalf("expected an orphan block, got %s", result.Status)
+		}
+	}
+	if state.OrphanCount() != 2 {
+		t.Fatalf("expected 2 orphan blocks, got %d", state.OrphanCount())
+	}
+
+	var result ImportResult
+	for _, block := range blocks[:2] {
+		result, err = state.ImportBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != BlockAppended {
+			t.Fatalf("expected an appended block, got %s", result.Status)
+		}
+	}
+	if len(result.Orphans) != 2 || result.Orphans[0].Status != BlockAppended || result.Orphans[1].Status != BlockAppended {
+		t.Fatalf("expected the 2 orphans to be appended after their missing parent, got %+v", result.Orphans)
+	}
+
+	if state.LatestBlockHash() != source.LatestBlockHash() {
+		t.Fatalf("expected the orphans to be connected up to %s, got %s", source.LatestBlockHash().Hex(), state.LatestBlockHash().Hex())
+	}
+	if state.OrphanCount() != 0 {
+		t.Fatalf("expected no orphan block left, got %d", state.OrphanCount())
+	}
+}
+
+func TestOrphanPoolBounds(t *testing.T) {
+	pool := newOrphanPool()
+	now := time.Unix(1_000_000, 0)
+
+	orphanOf := func(i int) (Hash, Block) {
+		return Hash{byte(i), 1}, Block{Header: BlockHeader{Height: uint64(i + 1), Parent: Hash{byte(i)}}}
+	}
+
+	for i := 0; i <= MaxOrphanBlocks; i++ {
+		hash, block := orphanOf(i)
+		pool.add(hash, block, now.Add(time.Duration(i)*time.Second))
+	}
+	if pool.count != MaxOrphanBlocks {
+		t.Fatalf("expected %d orphan blocks, got %d", MaxOrphanBlocks, pool.count)
+	}
+	if orphans := pool.take(Hash{0}, now); len(orphans) != 0 {
+		t.Fatal("the oldest orphan block must be evicted from a full pool")
+	}
+	if orphans := pool.take(Hash{1}, now); len(orphans) != 1 {
+		t.Fatalf("expected 1 orphan block of the parent, got %d", len(orphans))
+	}
+
+	// All the orphans added up to MaxOrphanAge ago are forgotten
+	later := now.Add(MaxOrphanAge + 50*time.Second)
+	if orphans := pool.take(Hash{2}, later); len(orphans) != 0 {
+		t.Fatal("an orphan block older than MaxOrphanAge must be forgotten")
+	}
+	if orphans := pool.take(Hash{99}, later); len(orphans) != 1 {
+		t.Fatal("a recent orphan block must be kept")
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"fmt"
+	"log"
+)
+
+// prunableStore is implemented by stores able to delete the oldest blocks of the chain
+type prunableStore interface {
+	// prune deletes the blocks below the height, a store may keep some of them
+	prune(below uint64) error
+	// prunedBelow returns the height of the oldest block still stored
+	prunedBelow() uint64
+}
+
+// ErrPruned is returned for blocks deleted by a pruned node
+type ErrPruned struct {
+	From uint64
+}
+
+func (e ErrPruned) Error() string {
+	return fmt.Sprintf("blocks below height %d are pruned on this node, fetch them from an archive node", e.From)
+}
+
+// prunedBelow returns the height of the oldest block kept by the store
+func (s *State) prunedBelow() uint64 {
+	ps, ok := s.store.(prunableStore)
+	if !ok {
+		return 0
+	}
+	return ps.prunedBelow()
+}
+
+// pruneBlocks deletes the blocks older than the last pruneKeep blocks. The block of the
+// oldest State snapshot and the blocks after it are always kept, so the State can be
+// restored from the snapshots on the next start.
+func (s *State) pruneBlocks() error {
+	ps, ok := s.store.(prunableStore)
+	if s.pruneKeep == 0 || !ok {
+		return nil
+	}
+
+	tip := s.latestBlock.Header.Height
+	if tip+1 <= s.pruneKeep {
+		return nil
+	}
+
+	heights, err := listSnapshotHeights(s.snapshotDir)
+	if err != nil || len(heights) == 0 {
+		return err
+	}
+
+	below := tip + 1 - s.pruneKeep
+	if oldestSnapshot := heights[len(heights)-1]; oldestSnapshot < below {
+		below = oldestSnapshot
+	}
+	pruned := ps.prunedBelow()
+	if below <= pruned {
+		return nil
+	}
+
+	err = ps.prune(below)
+	if err != nil {
+		return err
+	}
+
+	if ps.prunedBelow() > pruned {
+		log.Printf("Pruned the blocks below height %d\n", ps.prunedBelow())
+	}
+	return nil
+}
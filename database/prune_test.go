@@ -0,0 +1,174 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStatePruning(t *testing.T) {
+	stores := []string{BlockStoreFile, BlockStoreLevelDB}
+
+	for _, kind := range stores {
+		t.Run(kind, func(t *testing.T) {
+			dataDir := t.TempDir()
+			miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+			opts := Options{BlockStore: kind, SnapshotInterval: 10, SegmentSize: 1, Prune: 3}
+
+			state, err := NewStateFromDiskWithOptions(dataDir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var hashes []Hash
+			for i := 0; i < 120; i++ {
+				hash, err := state.AddBlock(mineTestBlock(t, state, miner))
+				if err != nil {
+					t.Fatal(err)
+				}
+				hashes = append(hashes, hash)
+			}
+			expectedBalance := state.Balances[miner]
+
+			// The last 3 blocks are kept, and the blocks from height 10, the oldest snapshot
+			// MaxReorgDepth blocks below the newest one at 110
+			if state.prunedBelow() != 10 {
+				t.Fatalf("expected the blocks below height 10 to be pruned, got %d", state.prunedBelow())
+			}
+
+			var errPruned ErrPruned
+			if _, err = state.GetBlocksAfter(Hash{}); !errors.As(err, &errPruned) {
+				t.Fatalf("syncing pruned blocks should fail with ErrPruned, got %v", err)
+			}
+			// A peer searching for a common block gets nothing for the hashes the node doesn't
+			// know, the hashes of its pruned blocks are forgotten too
+			for _, hash := range []Hash{{1}, hashes[1]} {
+				blocks, err := state.GetBlocksAfter(hash)
+				if err != nil || len(blocks) != 0 {
+					t.Fatalf("expected no blocks after the unknown block %s, got %d: %v", hash.Hex(), len(blocks), err)
+				}
+			}
+
+			blocks, err := state.GetBlocksAfter(hashes[117])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blocks) != 2 {
+				t.Fatalf("expected 2 blocks after height 117, got %d", len(blocks))
+			}
+
+			// The history of the pruned blocks isn't served anymore
+			entries, _, err := state.GetAccountTxns(miner, "", 0, DirectionAll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 || entries[0].Height != 10 {
+				t.Fatalf("expected the account history to start at height 10, got %+v", entries)
+			}
+			txnHash := Hash{1}
+			err = state.indexes.db.Put(indexTxnKey(txnHash), encodeTxnLocation(txnLocation{1, 0}), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = state.GetMinedTxn(txnHash.Hex()); !errors.As(err, &errPruned) {
+				t.Fatalf("getting a Txn of a pruned block should fail with ErrPruned, got %v", err)
+			}
+			state.Close()
+
+			// The State is restored from the snapshot and still accepts new blocks
+			state, err = NewStateFromDiskWithOptions(dataDir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.Close()
+
+			if state.LatestBlockHash() != hashes[119] || state.Balances[miner] != expectedBalance {
+				t.Fatal("the pruned State was not restored")
+			}
+			_, err = state.AddBlock(mineTestBlock(t, state, miner))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A pruned node still follows a heavier branch forking up to MaxReorgDepth blocks deep
+			branchMiner := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+			reorgOpts := Options{BlockStore: kind, SnapshotInterval: 10, Prune: 20}
+
+			reorgState, err := NewStateFromDiskWithOptions(t.TempDir(), reorgOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reorgState.Close()
+
+			branchState, err := NewStateFromDiskWithOptions(t.TempDir(), Options{BlockStore: kind})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer branchState.Close()
+
+			for i := 0; i < 100; i++ {
+				block := mineTestBlock(t, reorgState, miner)
+				if _, err = reorgState.AddBlock(block); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = branchState.AddBlock(block); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 50; i++ {
+				if _, err = reorgState.AddBlock(mineTestBlock(t, reorgState, miner)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var branch []Block
+			for i := 0; i < 51; i++ {
+				block := mineTestBlock(t, branchState, branchMiner)
+				if _, err = branchState.AddBlock(block); err != nil {
+					t.Fatal(err)
+				}
+				branch = append(branch, block)
+			}
+
+			// The branch forks 50 blocks deep, below the last 20 blocks and the newest snapshots
+			var result ImportResult
+			for _, block := range branch {
+				result, err = reorgState.ImportBlock(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if result.Status != BlockReorg || len(result.Connected) != 51 || len(result.Disconnected) != 50 {
+				t.Fatalf("expected a reorg replacing 50 blocks with 51, got %s replacing %d with %d", result.Status, len(result.Disconnected), len(result.Connected))
+			}
+			if reorgState.LatestBlockHash() != branchState.LatestBlockHash() {
+				t.Fatalf("expected the tip of the heavier branch %s, got %s", branchState.LatestBlockHash().Hex(), reorgState.LatestBlockHash().Hex())
+			}
+			if reorgState.Balances[branchMiner] != branchState.Balances[branchMiner] {
+				t.Fatalf("expected the balance %d for %s, got %d", branchState.Balances[branchMiner], branchMiner, reorgState.Balances[branchMiner])
+			}
+		})
+	}
+}
+
+func TestStatePruningSealsSegments(t *testing.T) {
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	opts := Options{BlockStore: BlockStoreFile, SnapshotInterval: 10, SegmentSize: DefaultSegmentSize, Prune: 3}
+
+	state, err := NewStateFromDiskWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	for i := 0; i < 120; i++ {
+		if _, err = state.AddBlock(mineTestBlock(t, state, miner)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The segments far from the segment size are sealed every 3 blocks, the ones holding
+	// only blocks below the oldest snapshot at height 10 are deleted
+	if state.prunedBelow() != 9 {
+		t.Fatalf("expected the blocks below height 9 to be pruned, got %d", state.prunedBelow())
+	}
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rlp"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+// Every block of blocks.db is framed as a record:
+//
+//	codec (1) | payload length (4) | crc32c of codec, length and payload (4) | payload
+//
+// so a record cut short by a crash can be detected and dropped on the next start.
+const (
+	recordHeaderSize = 1 + 4 + 4
+	maxRecordSize    = 256 << 20
+
+	recordCodecJSON byte = 'j'
+	recordCodecRLP  byte = 'r'
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errTornRecord    = errors.New("incomplete record")
+	errCorruptRecord = errors.New("record checksum mismatch")
+)
+
+// encodeRecord frames a block with the codec matching its encoding version.
+// Blocks prior to OIP-2 stay JSON so they are read back exactly as they were hashed.
+func encodeRecord(blockFs BlockFS) ([]byte, error) {
+	var payload []byte
+	var err error
+
+	codec := recordCodecJSON
+	if blockFs.Value.Header.Version == VersionRLP {
+		codec = recordCodecRLP
+		payload, err = rlp.EncodeToBytes(rlpBlockFS{blockFs.Key, toRLPBlock(blockFs.Value)})
+	} else {
+		payload, err = json.Marshal(blockFs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	record[0] = codec
+	binary.BigEndian.PutUint32(record[1:5], uint32(len(payload)))
+	copy(record[recordHeaderSize:], payload)
+	binary.BigEndian.PutUint32(record[5:9], recordChecksum(record))
+	return record, nil
+}
+
+func recordChecksum(record []byte) uint32 {
+	crc := crc32.Update(0, crcTable, record[:5])
+	return crc32.Update(crc, crcTable, record[recordHeaderSize:])
+}
+
+// readRecord reads the next record and returns its block and its size on disk.
+// io.EOF is returned when there are no more records.
+func readRecord(r io.Reader) (BlockFS, int64, error) {
+	var blockFs BlockFS
+
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return blockFs, 0, io.EOF
+	}
+	if err != nil {
+		return blockFs, int64(n), errTornRecord
+	}
+
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > maxRecordSize {
+		return blockFs, recordHeaderSize, errCorruptRecord
+	}
+
+	record := make([]byte, recordHeaderSize+int(length))
+	copy(record, header)
+	n, err = io.ReadFull(r, record[recordHeaderSize:])
+	if err != nil {
+		return blockFs, int64(recordHeaderSize + n), errTornRecord
+	}
+	size := int64(len(record))
+
+	if binary.BigEndian.Uint32(header[5:9]) != recordChecksum(record) {
+		return blockFs, size, errCorruptRecord
+	}
+
+	switch record[0] {
+	case recordCodecJSON:
+		err = json.Unmarshal(record[recordHeaderSize:], &blockFs)
+	case recordCodecRLP:
+		var r rlpBlockFS
+		err = rlp.DecodeBytes(record[recordHeaderSize:], &r)
+		blockFs = BlockFS{r.Key, r.Value.block()}
+	default:
+		err = fmt.Errorf("unknown record codec '%c'", record[0])
+	}
+	return blockFs, size, err
+}
+
+// isJSONLinesBlocksDb detects a blocks.db written before blocks were framed as records
+func isJSONLinesBlocksDb(f *os.File) (bool, error) {
+	first := make([]byte, 1)
+	_, err := f.ReadAt(first, 0)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return first[0] == '{', nil
+}
+
+// migrateJSONLinesBlocksDb rewrites a JSON lines blocks.db into framed records
+func migrateJSONLinesBlocksDb(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	count := 0
+	reader := bufio.NewReader(src)
+	writer := bufio.NewWriter(dst)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 1 {
+			var blockFs BlockFS
+			jsonErr := json.Unmarshal(line, &blockFs)
+			if jsonErr != nil && err == io.EOF {
+				// the last line was cut short by a crash
+				log.Printf("Dropping incomplete last block of %s (%d bytes)\n", path, len(line))
+				break
+			}
+			if jsonErr != nil {
+				return fmt.Errorf("unable to migrate block %d of %s: %s", count, path, jsonErr)
+			}
+
+			record, encodeErr := encodeRecord(blockFs)
+			if encodeErr != nil {
+				return encodeErr
+			}
+			if _, writeErr := writer.Write(record); writeErr != nil {
+				return writeErr
+			}
+			count++
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	err = dst.Sync()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d block(s) of %s to framed records\n", count, path)
+	return os.Rename(tmpPath, path)
+}
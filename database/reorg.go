@@ -0,0 +1,400 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"log"
+	"math"
+)
+
+// MaxReorgDepth is the number of blocks at the tip of the main chain a heavier branch can replace.
+// Blocks of side branches forking deeper than that are rejected and forgotten.
+const MaxReorgDepth = 100
+
+// MaxSideBlocks is the number of side branch blocks kept, the lowest are forgotten first
+const MaxSideBlocks = 1000
+
+const (
+	// BlockAppended is a block extending the tip of the main chain
+	BlockAppended = "appended"
+	// BlockSideChain is a block of a branch with less work than the main chain, kept aside
+	BlockSideChain = "side_chain"
+	// BlockReorg is a block making its branch heavier than the main chain, the branch replaced the tip of the main chain
+	BlockReorg = "reorg"
+	// BlockKnown is a block already part of the main chain or of a side branch
+	BlockKnown = "known"
+	// BlockOrphan is a block whose parent is unknown, kept until its parent is imported
+	BlockOrphan = "orphan"
+)
+
+// rewindableStore is implemented by stores able to delete the blocks at the tip of the chain
+type rewindableStore interface {
+	// rewind deletes the blocks with a height of at least from
+	rewind(from uint64) error
+}
+
+// ImportResult describes how an imported block changed the chain
+type ImportResult struct {
+	Hash   Hash
+	Status string
+	// Connected holds the blocks added to the main chain, in height order
+	Connected []Block
+	// Disconnected holds the blocks removed from the main chain by a reorganization, in height order
+	Disconnected []Block
+	// Orphans holds the results of the orphan blocks imported after the block, its descendants
+	// received before it, in the order they were imported
+	Orphans []ImportResult
+}
+
+// HasBlock tells if the block is part of the main chain or of a known side branch
+func (s *State) HasBlock(hash Hash) bool {
+	if _, ok := s.sideBlocks[hash]; ok {
+		return true
+	}
+	_, err := s.store.GetByHash(hash)
+	return err == nil
+}
+
+// ImportBlock adds the block to the heaviest chain. A block extending the tip of the main
+// chain is added like with AddBlock. A block of another branch is kept aside, and once its
+// branch has more accumulated work than the main chain after their common ancestor, the
+// State is rolled back to the ancestor and the branch is applied instead. A block whose
+// parent is unknown is kept as an orphan and imported once its parent is.
+func (s *State) ImportBlock(b Block) (ImportResult, error) {
+	result, err := s.importBlock(b)
+	if err != nil || result.Status == BlockKnown || result.Status == BlockOrphan {
+		return result, err
+	}
+	result.Orphans = s.connectOrphans(result.Hash)
+	return result, nil
+}
+
+func (s *State) importBlock(b Block) (ImportResult, error) {
+	hash, err := b.Hash()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if s.HasBlock(hash) {
+		return ImportResult{hash, BlockKnown, nil, nil, nil}, nil
+	}
+	err = s.chainConfig.ValidateCheckpoint(b.Header.Height, hash)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	isNextBlock := b.Header.Parent == s.latestBlockHash && b.Header.Height == s.NextBlockHeight()
+	if (!s.hasGenesisBlock && b.Header.Height == 0) || isNextBlock {
+		_, err = s.AddBlock(b)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		return ImportResult{hash, BlockAppended, []Block{b}, nil, nil}, nil
+	}
+
+	// Side blocks are only fully validated once their branch becomes the heaviest
+	err = s.consensus.VerifySeal(b, hash)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	err = s.validateFutureBlockTime(b)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	err = s.chainConfig.validateBlockLimits(b)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	// The hash of a header with a TxRoot doesn't cover the Txns, they must match it to keep the block aside
+	if !b.Header.TxRoot.IsEmpty() {
+		txRoot, err := ComputeTxRoot(b.Txns)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		if txRoot != b.Header.TxRoot {
+			return ImportResult{}, fmt.Errorf("block %s doesn't match its Txn root", hash.Hex())
+		}
+	}
+
+	// The branch of a block is only known once its parent is
+	if b.Header.Height > 0 && !s.HasBlock(b.Header.Parent) {
+		if b.Header.Height+MaxReorgDepth < s.NextBlockHeight() {
+			return ImportResult{}, fmt.Errorf("orphan block %s at height %d is more than %d blocks deep", hash.Hex(), b.Header.Height, MaxReorgDepth)
+		}
+		s.orphans.add(hash, b, s.clock.Now())
+		log.Printf("Block %s at height %d is an orphan, its parent %s is unknown\n", hash.Hex(), b.Header.Height, b.Header.Parent.Hex())
+		return ImportResult{hash, BlockOrphan, nil, nil, nil}, nil
+	}
+
+	branch, err := s.branchOf(b)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	from := branch[0].Header.Height
+	if s.latestBlock.Header.Height-from+1 > MaxReorgDepth {
+		return ImportResult{}, fmt.Errorf("block %s forks from the chain at height %d, more than %d blocks deep", hash.Hex(), from, MaxReorgDepth)
+	}
+	// The main chain holds the checkpoint blocks it reached, a branch can't replace them
+	if checkpoint, ok := s.chainConfig.latestCheckpointBelow(s.NextBlockHeight()); ok && from <= checkpoint.Height {
+		return ImportResult{}, fmt.Errorf("block %s forks from the chain at height %d, below the checkpoint at height %d", hash.Hex(), from, checkpoint.Height)
+	}
+	s.addSideBlock(hash, b)
+
+	branchWork := new(uint256.Int)
+	for _, block := range branch {
+		branchWork.Add(branchWork, uint256.NewInt(block.Header.Work()))
+	}
+	mainWork := new(uint256.Int)
+	err = s.store.Iterate(from, math.MaxUint64, func(blockFs BlockFS) error {
+		mainWork.Add(mainWork, uint256.NewInt(blockFs.Value.Header.Work()))
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	// On equal work the branch seen first stays the main chain
+	if branchWork.Cmp(mainWork) <= 0 {
+		log.Printf("Block %s at height %d is on a side branch with less work, %s <= %s\n", hash.Hex(), b.Header.Height, branchWork.Dec(), mainWork.Dec())
+		return ImportResult{hash, BlockSideChain, nil, nil, nil}, nil
+	}
+
+	disconnected, err := s.reorg(from, branch)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return ImportResult{hash, BlockReorg, branch, disconnected, nil}, nil
+}
+
+// branchOf returns the blocks of the side branch ending with the block, starting with
+// the first block after the common ancestor with the main chain
+func (s *State) branchOf(b Block) ([]Block, error) {
+	branch := []Block{b}
+	for {
+		first := branch[0]
+		if first.Header.Height == 0 {
+			if !first.Header.Parent.IsEmpty() {
+				return nil, fmt.Errorf("block at height 0 can't have a parent")
+			}
+			return branch, nil
+		}
+
+		if parent, ok := s.sideBlocks[first.Header.Parent]; ok {
+			if parent.Header.Height+1 != first.Header.Height {
+				return nil, fmt.Errorf("block at height %d doesn't follow its parent at height %d", first.Header.Height, parent.Header.Height)
+			}
+			branch = append([]Block{parent}, branch...)
+			continue
+		}
+
+		parentFs, err := s.store.GetByHash(first.Header.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("unknown parent %s of block at height %d", first.Header.Parent.Hex(), first.Header.Height)
+		}
+		if parentFs.Value.Header.Height+1 != first.Header.Height {
+			return nil, fmt.Errorf("block at height %d doesn't follow its parent at height %d", first.Header.Height, parentFs.Value.Header.Height)
+		}
+		return branch, nil
+	}
+}
+
+// addSideBlock keeps the block of a side branch and forgets the side blocks too deep to cause a reorg anymore.
+// Past MaxSideBlocks, the lowest side blocks are forgotten as well.
+func (s *State) addSideBlock(hash Hash, b Block) {
+	s.sideBlocks[hash] = b
+
+	for h, block := range s.sideBlocks {
+		if block.Header.Height+MaxReorgDepth < s.latestBlock.Header.Height {
+			delete(s.sideBlocks, h)
+		}
+	}
+
+	for len(s.sideBlocks) > MaxSideBlocks {
+		var lowest Hash
+		lowestHeight := uint64(math.MaxUint64)
+		for h, block := range s.sideBlocks {
+			if block.Header.Height < lowestHeight {
+				lowest, lowestHeight = h, block.Header.Height
+			}
+		}
+		delete(s.sideBlocks, lowest)
+	}
+}
+
+// reorg replaces the blocks of the main chain starting at the height from with the branch.
+// The branch is fully validated on top of the common ancestor, with the checks of AddBlock,
+// before the stored chain changes. The replaced blocks are returned and kept as a side branch.
+// When the branch still fails to be saved, the replaced blocks are put back.
+func (s *State) reorg(from uint64, branch []Block) ([]Block, error) {
+	rs, ok := s.store.(rewindableStore)
+	if !ok {
+		return nil, fmt.Errorf("the block store can't replace blocks of the chain")
+	}
+	if from < s.prunedBelow() {
+		return nil, fmt.Errorf("a heavier branch forks at height %d: %w", from, ErrPruned{s.prunedBelow()})
+	}
+
+	ancestor, err := s.stateBelow(from)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingState := ancestor.Copy()
+	for i, b := range branch {
+		err = pendingState.validateFutureBlockTime(b)
+		if err == nil {
+			err = applyBlock(b, &pendingState)
+		}
+		if err == nil {
+			pendingState.latestBlockHash, err = b.Hash()
+		}
+		if err != nil {
+			// The invalid block and its descendants can't become part of the chain
+			for _, invalid := range branch[i:] {
+				invalidHash, _ := invalid.Hash()
+				delete(s.sideBlocks, invalidHash)
+			}
+			return nil, fmt.Errorf("invalid block at height %d of a heavier branch: %s", b.Header.Height, err)
+		}
+		pendingState.latestBlock = b
+		pendingState.hasGenesisBlock = true
+	}
+
+	var disconnected []BlockFS
+	err = s.store.Iterate(from, math.MaxUint64, func(blockFs BlockFS) error {
+		disconnected = append(disconnected, blockFs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reorganizing the chain from height %d, %d block(s) replaced by a heavier branch of %d\n", from, len(disconnected), len(branch))
+
+	// The indexes must never point past the stored chain, they are rolled back first
+	err = s.indexes.unindexBlocks(disconnected, from)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unindex the replaced blocks: %s", err)
+	}
+
+	// The replaced blocks stay known as a side branch whatever happens to the stored chain
+	blocks := make([]Block, len(disconnected))
+	for i, blockFs := range disconnected {
+		blocks[i] = blockFs.Value
+		s.sideBlocks[blockFs.Key] = blockFs.Value
+	}
+	err = rs.rewind(from)
+	if err != nil {
+		for _, blockFs := range disconnected {
+			delete(s.sideBlocks, blockFs.Key)
+		}
+		// The replaced blocks are still stored, they are indexed again
+		indexErr := s.indexes.catchUp(s.store)
+		if indexErr != nil {
+			log.Printf("ERROR: unable to index the blocks again: %s\n", indexErr)
+		}
+		return nil, err
+	}
+
+	s.rollBackTo(ancestor, from)
+
+	for _, b := range branch {
+		_, err = s.AddBlock(b)
+		if err != nil {
+			restoreErr := s.restoreChain(rs, ancestor, from, blocks)
+			if restoreErr != nil {
+				return nil, fmt.Errorf("unable to add block at height %d of a heavier branch: %s, nor to put the replaced blocks back: %s", b.Header.Height, err, restoreErr)
+			}
+			return nil, fmt.Errorf("unable to add block at height %d of a heavier branch, the replaced blocks were put back: %s", b.Header.Height, err)
+		}
+	}
+
+	for _, b := range branch {
+		hash, _ := b.Hash()
+		delete(s.sideBlocks, hash)
+	}
+	return blocks, nil
+}
+
+// rollBackTo sets the State to the ancestor of the blocks from the height, after they were removed from the store.
+// The indexes and snapshots catch up again with the blocks added after it.
+func (s *State) rollBackTo(ancestor *State, from uint64) {
+	s.Balances = ancestor.Balances
+	s.AccountNonces = ancestor.AccountNonces
+	s.latestBlock = ancestor.latestBlock
+	s.latestBlockHash = ancestor.latestBlockHash
+	s.hasGenesisBlock = ancestor.hasGenesisBlock
+	s.totalWork = ancestor.totalWork
+	s.recentTimes = ancestor.recentTimes
+	s.recentSealers = ancestor.recentSealers
+
+	err := s.removeSnapshotsFrom(from)
+	if err != nil {
+		log.Printf("ERROR: unable to remove the State snapshots of the replaced blocks: %s\n", err)
+	}
+}
+
+// restoreChain removes the blocks of a branch added from the height and adds the replaced blocks back
+func (s *State) restoreChain(rs rewindableStore, ancestor *State, from uint64, replaced []Block) error {
+	var added []BlockFS
+	err := s.store.Iterate(from, math.MaxUint64, func(blockFs BlockFS) error {
+		added = append(added, blockFs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.indexes.unindexBlocks(added, from)
+	if err != nil {
+		return err
+	}
+	err = rs.rewind(from)
+	if err != nil {
+		return err
+	}
+
+	s.rollBackTo(ancestor, from)
+	for _, b := range replaced {
+		hash, err := s.AddBlock(b)
+		if err != nil {
+			return err
+		}
+		delete(s.sideBlocks, hash)
+	}
+	return nil
+}
+
+// stateBelow rebuilds the State as it was before the block at the height was applied,
+// from the newest snapshot below the height and the stored blocks after it
+func (s *State) stateBelow(height uint64) (*State, error) {
+	c := s.Copy()
+	c.store = s.store
+	c.snapshotDir = s.snapshotDir
+	c.Balances = make(map[common.Address]Amount)
+	c.AccountNonces = make(map[common.Address]uint)
+	c.latestBlock = Block{}
+	c.latestBlockHash = Hash{}
+	c.hasGenesisBlock = false
+	c.totalWork.Clear()
+	c.recentTimes = nil
+	c.recentSealers = nil
+
+	for account, balance := range s.genesisBalances {
+		c.Balances[account] = balance
+	}
+	if height == 0 {
+		return &c, nil
+	}
+
+	_, err := c.loadLatestSnapshot(height)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.replay(c.NextBlockHeight(), height-1, true)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestStateReorg(t *testing.T) {
+	stores := []string{BlockStoreFile, BlockStoreLevelDB}
+
+	for _, kind := range stores {
+		t.Run(kind, func(t *testing.T) {
+			key, sender := newTestAccount(t)
+			minerA := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+			minerB := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+			genesisBalances := map[common.Address]Amount{sender: NewAmount(1000)}
+			opts := Options{BlockStore: kind, SnapshotInterval: 2, SegmentSize: 1}
+
+			dataDir := setupTestDataDir(t, genesisBalances)
+			state, err := NewStateFromDiskWithOptions(dataDir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			branchState, err := NewStateFromDiskWithOptions(setupTestDataDir(t, genesisBalances), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer branchState.Close()
+
+			// Both chains share the block at height 0
+			genesisBlock := mineTestBlock(t, state, minerA)
+			if _, err = state.AddBlock(genesisBlock); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = branchState.AddBlock(genesisBlock); err != nil {
+				t.Fatal(err)
+			}
+
+			txn := newTestTxn(sender, minerA, NewAmount(10), 1, "")
+			for _, txns := range [][]SignedTxn{nil, {signTestTxn(t, txn, key)}} {
+				if _, err = state.AddBlock(mineTestBlock(t, state, minerA, txns...)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var branch []Block
+			for i := 0; i < 3; i++ {
+				block := mineTestBlock(t, branchState, minerB)
+				if _, err = branchState.AddBlock(block); err != nil {
+					t.Fatal(err)
+				}
+				branch = append(branch, block)
+			}
+
+			// The branch has the same work as the main chain after its 2nd block
+			for _, block := range branch[:2] {
+				result, err := state.ImportBlock(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Status != BlockSideChain {
+					t.Fatalf("expected a side chain block, got %s", result.Status)
+				}
+			}
+			result, err := state.ImportBlock(branch[1])
+			if err != nil || result.Status != BlockKnown {
+				t.Fatalf("expected a known block, got %s: %v", result.Status, err)
+			}
+
+			result, err = state.ImportBlock(branch[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != BlockReorg || len(result.Connected) != 3 || len(result.Disconnected) != 2 {
+				t.Fatalf("expected a reorg replacing 2 blocks with 3, got %s replacing %d with %d", result.Status, len(result.Disconnected), len(result.Connected))
+			}
+
+			if state.LatestBlockHash() != branchState.LatestBlockHash() {
+				t.Fatalf("expected the tip of the heavier branch %s, got %s", branchState.LatestBlockHash().Hex(), state.LatestBlockHash().Hex())
+			}
+			if !state.TotalWork().Eq(branchState.TotalWork()) {
+				t.Fatalf("expected the total work %s of the heavier branch, got %s", branchState.TotalWork().Dec(), state.TotalWork().Dec())
+			}
+			for _, account := range []common.Address{sender, minerA, minerB} {
+				if state.Balances[account] != branchState.Balances[account] {
+					t.Fatalf("expected the balance %d for %s, got %d", branchState.Balances[account], account, state.Balances[account])
+				}
+			}
+			if state.GetNextAccountNonce(sender) != 1 {
+				t.Fatal("the Txn of the replaced block should not be applied anymore")
+			}
+
+			txnHash, err := txn.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = state.GetMinedTxn(txnHash.Hex()); err == nil {
+				t.Fatal("the Txn of the replaced block should not be indexed anymore")
+			}
+			// Nor found through an index entry left behind, the block at its height was replaced
+			err = state.indexes.db.Put(indexTxnKey(txnHash), encodeTxnLocation(txnLocation{2, 0}), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = state.GetMinedTxn(txnHash.Hex()); err == nil {
+				t.Fatal("an index entry pointing to a replaced block must not return another Txn")
+			}
+
+			// The replaced blocks are a side branch now and the chain keeps growing on the new tip
+			if !state.HasBlock(mustBlockHash(t, result.Disconnected[1])) {
+				t.Fatal("the replaced blocks should be kept as a side branch")
+			}
+			if _, err = state.AddBlock(mineTestBlock(t, state, minerA)); err != nil {
+				t.Fatal(err)
+			}
+			totalWork := state.TotalWork()
+			state.Close()
+
+			// The stored chain was replaced too
+			state, err = NewStateFromDiskWithOptions(dataDir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.Close()
+
+			block, err := state.GetBlockByHashOrHeight(4, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.Value.Header.Parent != branchState.LatestBlockHash() {
+				t.Fatal("the block mined after the reorg should follow the heavier branch")
+			}
+			if state.Balances[minerB] != branchState.Balances[minerB] {
+				t.Fatalf("expected the balance %d for %s after a restart, got %d", branchState.Balances[minerB], minerB, state.Balances[minerB])
+			}
+			if !state.TotalWork().Eq(totalWork) {
+				t.Fatalf("expected the total work %s after a restart, got %s", totalWork.Dec(), state.TotalWork().Dec())
+			}
+		})
+	}
+}
+
+// failingAppendStore fails the failAt-th block appended to the store
+type failingAppendStore struct {
+	BlockStore
+	appends int
+	failAt  int
+}
+
+func (fs *failingAppendStore) Append(blockFs BlockFS) error {
+	fs.appends++
+	if fs.appends == fs.failAt {
+		return errors.New("no space left on device")
+	}
+	return fs.BlockStore.Append(blockFs)
+}
+
+func (fs *failingAppendStore) rewind(from uint64) error {
+	return fs.BlockStore.(rewindableStore).rewind(from)
+}
+
+func TestStateReorgPutsReplacedBlocksBack(t *testing.T) {
+	minerA := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	minerB := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+
+	state := newTestState(t, Genesis{Symbol: "OPB"})
+	defer state.Close()
+	branchState := newTestState(t, Genesis{Symbol: "OPB"})
+	defer branchState.Close()
+
+	genesisBlock := mineTestBlock(t, state, minerA)
+	if _, err := state.AddBlock(genesisBlock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := branchState.AddBlock(genesisBlock); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := state.AddBlock(mineTestBlock(t, state, minerA)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip := state.LatestBlockHash()
+	balance := state.Balances[minerA]
+
+	var branch []Block
+	for i := 0; i < 3; i++ {
+		block := mineTestBlock(t, branchState, minerB)
+		if _, err := branchState.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, block)
+	}
+	for _, block := range branch[:2] {
+		if _, err := state.ImportBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The 2nd block of the branch fails to be saved once the replaced blocks were removed
+	state.store = &failingAppendStore{BlockStore: state.store, failAt: 2}
+	if _, err := state.ImportBlock(branch[2]); err == nil || !strings.Contains(err.Error(), "put back") {
+		t.Fatalf("expected the reorg to fail and put the replaced blocks back, got error: %v", err)
+	}
+
+	if state.LatestBlockHash() != tip || state.Balances[minerA] != balance {
+		t.Fatalf("expected the State back on the tip %s, got %s", tip.Hex(), state.LatestBlockHash().Hex())
+	}
+	stored, err := state.GetBlockByHashOrHeight(0, tip.Hex())
+	if err != nil || stored.Value.Header.Height != 2 {
+		t.Fatalf("expected the old tip stored at height 2, got %v", err)
+	}
+	for _, block := range branch {
+		if !state.HasBlock(mustBlockHash(t, block)) {
+			t.Fatal("the blocks of the branch should stay known as a side branch")
+		}
+	}
+	if _, err = state.AddBlock(mineTestBlock(t, state, minerA)); err != nil {
+		t.Fatalf("the chain should keep growing on the old tip, got error: %v", err)
+	}
+}
+
+func TestStateBoundsSideBlocks(t *testing.T) {
+	state := newTestState(t, Genesis{Symbol: "OPB"})
+	defer state.Close()
+
+	for i := uint64(0); i <= MaxSideBlocks; i++ {
+		block := NewBlock(i+1, Hash{1}, 0, 0, NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685"), nil)
+		state.addSideBlock(mustBlockHash(t, block), block)
+	}
+
+	if len(state.sideBlocks) != MaxSideBlocks {
+		t.Fatalf("expected %d side blocks kept, got %d", MaxSideBlocks, len(state.sideBlocks))
+	}
+	for _, block := range state.sideBlocks {
+		if block.Header.Height == 1 {
+			t.Fatal("the lowest side block should be forgotten first")
+		}
+	}
+}
+
+func mustBlockHash(t *testing.T, b Block) Hash {
+	hash, err := b.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
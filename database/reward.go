@@ -0,0 +1,176 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math"
+)
+
+// maxHalvings is the number of halvings after which any reward is down to 0, the width of an Amount
+const maxHalvings = 256
+
+// RewardSchedule defines the block rewards of the chain in genesis.json. The reward of a block is
+// either halved every HalvingInterval blocks or taken from the Steps table, never both.
+type RewardSchedule struct {
+	// InitialReward is the reward of the first block, and of the blocks before the first step
+	InitialReward   Amount       `json:"initial_reward"`
+	HalvingInterval uint64       `json:"halving_interval,omitempty"`
+	Steps           []RewardStep `json:"steps,omitempty"`
+	// MaxSupply caps the genesis balances plus the minted rewards, 0 for no cap
+	MaxSupply Amount `json:"max_supply"`
+}
+
+// RewardStep sets the reward of the blocks starting at the height, until the next step
+type RewardStep struct {
+	Height uint64 `json:"height"`
+	Reward Amount `json:"reward"`
+}
+
+// defaultRewardSchedule pays Reward forever, the schedule of a genesis.json without reward_schedule
+var defaultRewardSchedule = RewardSchedule{InitialReward: NewAmount(Reward)}
+
+// Supply describes the tokens of the chain at its latest block
+type Supply struct {
+	Height uint64 `json:"block_height"`
+	// Circulating is the sum of all the balances, the genesis balances plus the minted rewards
+	Circulating Amount `json:"circulating_supply"`
+	// Minted is the sum of the block rewards paid to date
+	Minted     Amount `json:"minted"`
+	NextReward Amount `json:"next_reward"`
+	MaxSupply  Amount `json:"max_supply"`
+}
+
+// blockRewards computes the block rewards of a chain from its schedule. The reward of a block
+// only depends on its height, the supply minted before a height is known without the State.
+type blockRewards struct {
+	schedule RewardSchedule
+	// premine is the sum of the genesis balances, counted in the max supply
+	premine Amount
+}
+
+func newBlockRewards(schedule *RewardSchedule, genesisBalances map[common.Address]Amount) (blockRewards, error) {
+	if schedule == nil {
+		schedule = &defaultRewardSchedule
+	}
+	if schedule.HalvingInterval > 0 && len(schedule.Steps) > 0 {
+		return blockRewards{}, fmt.Errorf("the reward schedule can't have both a halving interval and steps")
+	}
+	for i := 1; i < len(schedule.Steps); i++ {
+		if schedule.Steps[i].Height <= schedule.Steps[i-1].Height {
+			return blockRewards{}, fmt.Errorf("the reward steps must be sorted by increasing height")
+		}
+	}
+
+	premine := Amount{}
+	for _, balance := range genesisBalances {
+		var err error
+		premine, err = premine.Add(balance)
+		if err != nil {
+			return blockRewards{}, fmt.Errorf("the genesis balances overflow: %s", err)
+		}
+	}
+	return blockRewards{*schedule, premine}, nil
+}
+
+// scheduled returns the reward of the schedule at the height, ignoring the max supply,
+// and the height the reward changes at
+func (r blockRewards) scheduled(height uint64) (Amount, uint64) {
+	if r.schedule.HalvingInterval > 0 {
+		halvings := height / r.schedule.HalvingInterval
+		if halvings >= maxHalvings {
+			return Amount{}, math.MaxUint64
+		}
+		until := (halvings + 1) * r.schedule.HalvingInterval
+		if until/r.schedule.HalvingInterval != halvings+1 {
+			until = math.MaxUint64
+		}
+		return r.schedule.InitialReward.Rsh(uint(halvings)), until
+	}
+
+	reward := r.schedule.InitialReward
+	for _, step := range r.schedule.Steps {
+		if step.Height > height {
+			return reward, step.Height
+		}
+		reward = step.Reward
+	}
+	return reward, math.MaxUint64
+}
+
+// mintedBefore returns the sum of the rewards of the blocks below the height
+func (r blockRewards) mintedBefore(height uint64) (Amount, error) {
+	// Below the max supply, the minted rewards stop at the room left by the genesis balances
+	capped := !r.schedule.MaxSupply.IsZero()
+	room := Amount{}
+	if r.schedule.MaxSupply.Cmp(r.premine) > 0 {
+		room, _ = r.schedule.MaxSupply.Sub(r.premine)
+	}
+
+	minted := Amount{}
+	for from := uint64(0); from < height; {
+		reward, until := r.scheduled(from)
+		if until > height {
+			until = height
+		}
+		rewards, err := reward.MulUint(uint(until - from))
+		if err == nil {
+			minted, err = minted.Add(rewards)
+		}
+		if capped && (err != nil || minted.Cmp(room) > 0) {
+			return room, nil
+		}
+		if err != nil {
+			return Amount{}, fmt.Errorf("the rewards minted before height %d overflow: %s", height, err)
+		}
+		from = until
+	}
+	return minted, nil
+}
+
+// reward returns the reward of the block at the height, the last block below the max supply
+// gets the rest of it and the blocks after it get nothing
+func (r blockRewards) reward(height uint64) (Amount, error) {
+	if r.schedule.MaxSupply.IsZero() {
+		reward, _ := r.scheduled(height)
+		return reward, nil
+	}
+
+	after, err := r.mintedBefore(height + 1)
+	if err != nil {
+		return Amount{}, err
+	}
+	before, err := r.mintedBefore(height)
+	if err != nil {
+		return Amount{}, err
+	}
+	return after.Sub(before)
+}
+
+// Supply returns the supply of the chain at the latest block
+func (s *State) Supply() (Supply, error) {
+	circulating := Amount{}
+	for _, balance := range s.Balances {
+		var err error
+		circulating, err = circulating.Add(balance)
+		if err != nil {
+			return Supply{}, err
+		}
+	}
+
+	nextHeight := s.NextBlockHeight()
+	minted, err := s.rewards.mintedBefore(nextHeight)
+	if err != nil {
+		return Supply{}, err
+	}
+	nextReward, err := s.rewards.reward(nextHeight)
+	if err != nil {
+		return Supply{}, err
+	}
+	return Supply{
+		Height:      s.latestBlock.Header.Height,
+		Circulating: circulating,
+		Minted:      minted,
+		NextReward:  nextReward,
+		MaxSupply:   s.rewards.schedule.MaxSupply,
+	}, nil
+}
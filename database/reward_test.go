@@ -0,0 +1,205 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"testing"
+)
+
+func TestBlockRewards(t *testing.T) {
+	premine := map[common.Address]Amount{NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D"): NewAmount(1000)}
+
+	tests := []struct {
+		name     string
+		schedule *RewardSchedule
+		// expected are the rewards of the blocks at heights 0 to len(expected)-1
+		expected []uint
+	}{
+		{"default", nil, []uint{Reward, Reward, Reward}},
+		{"halvings", &RewardSchedule{InitialReward: NewAmount(100), HalvingInterval: 2}, []uint{100, 100, 50, 50, 25, 25, 12}},
+		{"steps", &RewardSchedule{InitialReward: NewAmount(100), Steps: []RewardStep{{2, NewAmount(40)}, {3, NewAmount(10)}}}, []uint{100, 100, 40, 10, 10}},
+		{"max supply", &RewardSchedule{InitialReward: NewAmount(100), MaxSupply: NewAmount(1250)}, []uint{100, 100, 50, 0, 0}},
+		{"max supply below the premine", &RewardSchedule{InitialReward: NewAmount(100), MaxSupply: NewAmount(500)}, []uint{0, 0}},
+	}
+	for _, tc := range tests {
+		rewards, err := newBlockRewards(tc.schedule, premine)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		minted := uint(0)
+		for height, expected := range tc.expected {
+			if reward, err := rewards.reward(uint64(height)); err != nil || reward != NewAmount(uint64(expected)) {
+				t.Fatalf("%s: expected reward %d at height %d, got %s: %v", tc.name, expected, height, reward, err)
+			}
+			if mintedBefore, err := rewards.mintedBefore(uint64(height)); err != nil || mintedBefore != NewAmount(uint64(minted)) {
+				t.Fatalf("%s: expected %d minted before height %d, got %s: %v", tc.name, minted, height, mintedBefore, err)
+			}
+			minted += expected
+		}
+	}
+
+	_, err := newBlockRewards(&RewardSchedule{InitialReward: NewAmount(100), HalvingInterval: 2, Steps: []RewardStep{{2, NewAmount(40)}}}, premine)
+	if err == nil {
+		t.Fatal("a schedule with both halvings and steps must be rejected")
+	}
+	_, err = newBlockRewards(&RewardSchedule{InitialReward: NewAmount(100), Steps: []RewardStep{{3, NewAmount(40)}, {2, NewAmount(10)}}}, premine)
+	if err == nil {
+		t.Fatal("a schedule with unsorted steps must be rejected")
+	}
+
+	// A reward above 64 bits keeps halving until it's down to 0
+	hugeReward, err := ParseAmount("1180591620717411303424") // 2^70
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewards, err := newBlockRewards(&RewardSchedule{InitialReward: hugeReward, HalvingInterval: 1}, premine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for height, expected := range map[uint64]uint64{64: 64, 70: 1, 71: 0} {
+		if reward, err := rewards.reward(height); err != nil || reward != NewAmount(expected) {
+			t.Fatalf("expected reward %d at height %d, got %s: %v", expected, height, reward, err)
+		}
+	}
+	expectedMinted, err := ParseAmount("2361183241434822606847") // 2^71 - 1
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minted, err := rewards.mintedBefore(100); err != nil || minted != expectedMinted {
+		t.Fatalf("expected %s minted, got %s: %v", expectedMinted, minted, err)
+	}
+
+	// The minted rewards can't wrap around, they stop at the max supply or overflow
+	maxAmount := Amount(*new(uint256.Int).SetAllOne())
+	rewards, err = newBlockRewards(&RewardSchedule{InitialReward: maxAmount}, premine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rewards.mintedBefore(2); err == nil {
+		t.Fatal("overflowing minted rewards must be rejected")
+	}
+	rewards, err = newBlockRewards(&RewardSchedule{InitialReward: maxAmount, MaxSupply: maxAmount}, premine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRoom, _ := maxAmount.Sub(NewAmount(1000))
+	if minted, err := rewards.mintedBefore(2); err != nil || minted != expectedRoom {
+		t.Fatalf("expected the minted rewards capped at %s, got %s: %v", expectedRoom, minted, err)
+	}
+}
+
+func TestStateEnforcesRewardSchedule(t *testing.T) {
+	dataDir := t.TempDir()
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	schedule := &RewardSchedule{InitialReward: NewAmount(100), HalvingInterval: 1, MaxSupply: NewAmount(1170)}
+	genesis, err := json.Marshal(Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", Forks: testForks(nil), RewardSchedule: schedule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	// Rewards of 100, 50, then 20 instead of 25 to reach the max supply, then only the fees
+	txn := newTestTxn(sender, miner, NewAmount(10), 1, "")
+	blocks := [][]SignedTxn{nil, nil, nil, {signTestTxn(t, txn, key)}}
+	for _, txns := range blocks {
+		_, err = state.AddBlock(mineTestBlock(t, state, miner, txns...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectedBalance := NewAmount(100 + 50 + 20 + 10 + TxnGas*DefaultGasPrice)
+	if state.Balances[miner] != expectedBalance {
+		t.Fatalf("expected the miner balance %s, got %s", expectedBalance, state.Balances[miner])
+	}
+
+	supply, err := state.Supply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Supply{Height: 3, Circulating: NewAmount(1170), Minted: NewAmount(170), NextReward: NewAmount(0), MaxSupply: NewAmount(1170)}
+	if supply != expected {
+		t.Fatalf("expected the supply %+v, got %+v", expected, supply)
+	}
+
+	txns, _, err := state.GetAccountTxns(miner, "", 0, DirectionIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewards := Amount{}
+	for _, entry := range txns {
+		if entry.Kind == AccountTxnReward {
+			rewards, err = rewards.Add(entry.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if rewards != NewAmount(170) {
+		t.Fatalf("expected 170 of indexed rewards, got %s", rewards)
+	}
+}
+
+func TestStateMintsRewardsAbove64Bits(t *testing.T) {
+	dataDir := t.TempDir()
+	_, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	parse := func(amount string) Amount {
+		parsed, err := ParseAmount(amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	schedule := &RewardSchedule{InitialReward: parse("18446744073709551616"), Steps: []RewardStep{{2, parse("36893488147419103232")}}}
+	genesis, err := json.Marshal(Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", Forks: testForks(nil), RewardSchedule: schedule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDataDirIfNotExists(dataDir, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	// Rewards of 2^64, 2^64 then 2^65
+	for i := 0; i < 3; i++ {
+		_, err = state.AddBlock(mineTestBlock(t, state, miner))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	minted := parse("73786976294838206464")
+	if state.Balances[miner] != minted {
+		t.Fatalf("expected the miner balance %s, got %s", minted, state.Balances[miner])
+	}
+
+	supply, err := state.Supply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Supply{Height: 2, Circulating: parse("73786976294838207464"), Minted: minted, NextReward: parse("36893488147419103232")}
+	if supply != expected {
+		t.Fatalf("expected the supply %+v, got %+v", expected, supply)
+	}
+}
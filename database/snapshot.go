@@ -0,0 +1,329 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultSnapshotInterval = 100
+	snapshotsToKeep         = 2
+	snapshotFilePrefix      = "snapshot-"
+	snapshotFileExt         = ".json"
+)
+
+// Snapshot is the State right after the block at Height was applied
+type Snapshot struct {
+	Height        uint64                    `json:"height"`
+	BlockHash     Hash                      `json:"block_hash"`
+	LatestBlock   Block                     `json:"latest_block"`
+	Balances      map[common.Address]Amount `json:"balances"`
+	AccountNonces map[common.Address]uint   `json:"account_nonces"`
+	// Forks are the activation heights of the OIPs the snapshot was taken with, as in genesis.json
+	Forks         map[string]uint64 `json:"-"`
+	RecentTimes   []uint64          `json:"recent_times,omitempty"`
+	RecentSealers []common.Address  `json:"recent_sealers,omitempty"`
+	// TotalWork is the work accumulated by the chain up to the block, see State.TotalWork
+	TotalWork *uint256.Int `json:"total_work,omitempty"`
+}
+
+type snapshotFile struct {
+	Snapshot Snapshot `json:"snapshot"`
+	Checksum Hash     `json:"checksum"`
+}
+
+// rawSnapshotFile reads back a snapshotFile, the checksum is verified on the snapshot as written
+// so snapshots taken before new fields were added stay valid
+type rawSnapshotFile struct {
+	Snapshot json.RawMessage `json:"snapshot"`
+	Checksum Hash            `json:"checksum"`
+}
+
+// snapshotFields is the Snapshot without its JSON methods
+type snapshotFields Snapshot
+
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	content, err := json.Marshal(snapshotFields(s))
+	if err != nil {
+		return nil, err
+	}
+	return writeForks(content, s.Forks)
+}
+
+// UnmarshalJSON reads the fork_<name> keys into Forks, the forks missing from a snapshot
+// taken before they were introduced were disabled
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var fields snapshotFields
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	fields.Forks, err = readForks(data)
+	if err != nil {
+		return err
+	}
+	*s = Snapshot(fields)
+	return nil
+}
+
+func (s Snapshot) checksum() (Hash, error) {
+	snapshotJson, err := json.Marshal(s)
+	if err != nil {
+		return Hash{}, err
+	}
+	return sha256.Sum256(snapshotJson), nil
+}
+
+func getSnapshotFilePath(snapshotDir string, height uint64) string {
+	return filepath.Join(snapshotDir, fmt.Sprintf("%s%020d%s", snapshotFilePrefix, height, snapshotFileExt))
+}
+
+func (s *State) snapshot() Snapshot {
+	c := s.Copy()
+	return Snapshot{
+		Height:        c.latestBlock.Header.Height,
+		BlockHash:     c.latestBlockHash,
+		LatestBlock:   c.latestBlock,
+		Balances:      c.Balances,
+		AccountNonces: c.AccountNonces,
+		Forks:         c.chainConfig.forks,
+		RecentTimes:   c.recentTimes,
+		RecentSealers: c.recentSealers,
+		TotalWork:     c.TotalWork(),
+	}
+}
+
+// writeSnapshot saves the current State to the snapshots dir and removes the snapshots
+// no longer needed to restore it or to reorganize the chain
+func (s *State) writeSnapshot() error {
+	snapshot := s.snapshot()
+	checksum, err := snapshot.checksum()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(snapshotFile{snapshot, checksum})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.snapshotDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path := getSnapshotFilePath(s.snapshotDir, snapshot.Height)
+	err = os.WriteFile(path+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return err
+	}
+	log.Printf("Saved State snapshot at height %d\n", snapshot.Height)
+
+	heights, err := listSnapshotHeights(s.snapshotDir)
+	if err != nil {
+		return err
+	}
+	// Older snapshots are kept until one is MaxReorgDepth blocks deep, a reorg rebuilds
+	// the State below the fork from it
+	keep := snapshotsToKeep
+	for keep < len(heights) && heights[keep-1]+MaxReorgDepth > snapshot.Height {
+		keep++
+	}
+	for i := keep; i < len(heights); i++ {
+		_ = os.Remove(getSnapshotFilePath(s.snapshotDir, heights[i]))
+	}
+	return nil
+}
+
+// listSnapshotHeights returns the heights of the saved snapshots, newest first
+func listSnapshotHeights(snapshotDir string) ([]uint64, error) {
+	entries, err := os.ReadDir(snapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	heights := make([]uint64, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, snapshotFilePrefix) || !strings.HasSuffix(name, snapshotFileExt) {
+			continue
+		}
+
+		var height uint64
+		_, err := fmt.Sscanf(strings.TrimPrefix(name, snapshotFilePrefix), "%020d", &height)
+		if err != nil {
+			continue
+		}
+		heights = append(heights, height)
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] > heights[j]
+	})
+	return heights, nil
+}
+
+func readSnapshot(path string) (Snapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var file rawSnapshotFile
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if sha256.Sum256(file.Snapshot) != file.Checksum {
+		return Snapshot{}, fmt.Errorf("invalid checksum")
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(file.Snapshot, &snapshot)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// loadLatestSnapshot restores the State from the newest snapshot taken on this chain below the height.
+// Snapshots with a bad checksum, other fork settings, a block hash not matching the stored
+// block at their height or a State not matching its state root are skipped.
+func (s *State) loadLatestSnapshot(below uint64) (bool, error) {
+	heights, err := listSnapshotHeights(s.snapshotDir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, height := range heights {
+		if height >= below {
+			continue
+		}
+
+		path := getSnapshotFilePath(s.snapshotDir, height)
+		snapshot, err := readSnapshot(path)
+		if err != nil {
+			log.Printf("Ignoring snapshot %s: %s\n", path, err)
+			continue
+		}
+
+		snapshotConfig, err := NewChainConfig(snapshot.Forks, nil, nil)
+		if err != nil || !snapshotConfig.Equal(s.chainConfig) {
+			log.Printf("Ignoring snapshot %s: taken with different fork settings\n", path)
+			continue
+		}
+
+		blockFs, err := s.store.GetByHeight(snapshot.Height)
+		if err != nil || blockFs.Key != snapshot.BlockHash {
+			log.Printf("Ignoring snapshot %s: block %s is not part of the stored chain\n", path, snapshot.BlockHash.Hex())
+			continue
+		}
+
+		if snapshot.Balances == nil {
+			snapshot.Balances = make(map[common.Address]Amount)
+		}
+		if snapshot.AccountNonces == nil {
+			snapshot.AccountNonces = make(map[common.Address]uint)
+		}
+
+		// Since OIP-6 the block commits to the State of the snapshot
+		if !blockFs.Value.Header.StateRoot.IsEmpty() {
+			snapshotState := State{Balances: snapshot.Balances, AccountNonces: snapshot.AccountNonces}
+			stateRoot, err := snapshotState.stateRoot()
+			if err != nil || stateRoot != blockFs.Value.Header.StateRoot {
+				log.Printf("Ignoring snapshot %s: the State doesn't match the state root of block %s\n", path, snapshot.BlockHash.Hex())
+				continue
+			}
+		}
+
+		s.Balances = snapshot.Balances
+		s.AccountNonces = snapshot.AccountNonces
+		s.latestBlock = snapshot.LatestBlock
+		s.latestBlockHash = snapshot.BlockHash
+		s.hasGenesisBlock = true
+		s.recentTimes = snapshot.RecentTimes
+		if s.recentTimes == nil {
+			s.recentTimes = s.loadRecentTimes(snapshot.Height)
+		}
+		s.recentSealers = snapshot.RecentSealers
+		if s.recentSealers == nil {
+			s.recentSealers = s.loadRecentSealers(snapshot.Height)
+		}
+		if snapshot.TotalWork != nil {
+			s.totalWork = *snapshot.TotalWork
+		} else {
+			s.totalWork, err = s.storedWork(snapshot.Height)
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// removeSnapshotsFrom deletes the snapshots taken at the height or above, they describe blocks
+// removed from the chain
+func (s *State) removeSnapshotsFrom(height uint64) error {
+	heights, err := listSnapshotHeights(s.snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range heights {
+		if h < height {
+			break
+		}
+		err = os.Remove(getSnapshotFilePath(s.snapshotDir, h))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRecentTimes reads the times of the last blocks up to the height from the store,
+// for snapshots taken before the block times were part of them
+func (s *State) loadRecentTimes(height uint64) []uint64 {
+	from := uint64(0)
+	if height+1 > MedianTimeBlocks {
+		from = height + 1 - MedianTimeBlocks
+	}
+
+	var times []uint64
+	_ = s.store.Iterate(from, height, func(blockFs BlockFS) error {
+		times = append(times, blockFs.Value.Header.Time)
+		return nil
+	})
+	return times
+}
+
+// storedWork sums the work of the stored blocks up to the height, for the snapshots taken before
+// they held the total work. The pruned blocks are counted with the minimum work.
+func (s *State) storedWork(height uint64) (uint256.Int, error) {
+	var work uint256.Int
+	prunedBelow := s.prunedBelow()
+	work.Mul(uint256.NewInt(MinDifficulty), uint256.NewInt(prunedBelow))
+
+	err := s.store.Iterate(prunedBelow, height, func(blockFs BlockFS) error {
+		work.Add(&work, uint256.NewInt(blockFs.Value.Header.Work()))
+		return nil
+	})
+	return work, err
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"testing"
+)
+
+func TestStateSnapshots(t *testing.T) {
+	dataDir := t.TempDir()
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	opts := Options{BlockStore: BlockStoreFile, SnapshotInterval: 2}
+
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = state.AddBlock(mineTestBlock(t, state, miner))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedBalance := state.Balances[miner]
+	expectedWork := state.TotalWork()
+	state.Close()
+
+	heights, err := listSnapshotHeights(getSnapshotsDirPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// None is MaxReorgDepth blocks deep yet, so the oldest ones are kept too
+	if len(heights) != 3 || heights[0] != 4 || heights[1] != 2 || heights[2] != 0 {
+		t.Fatalf("expected snapshots at heights [4 2 0], got %v", heights)
+	}
+
+	// A snapshot taken on a different chain must be rejected
+	path := getSnapshotFilePath(getSnapshotsDirPath(dataDir), 4)
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.BlockHash[0] ^= 0xff
+	snapshot.Balances[miner] = NewAmount(1_000_000)
+	checksum, err := snapshot.checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(snapshotFile{snapshot, checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshots taken before they held the total work get it from the stored blocks
+	path = getSnapshotFilePath(getSnapshotsDirPath(dataDir), 2)
+	snapshot, err = readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.TotalWork = nil
+	checksum, err = snapshot.checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = json.Marshal(snapshotFile{snapshot, checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.LatestBlock().Header.Height != 4 {
+		t.Fatalf("expected state at height 4, got %d", state.LatestBlock().Header.Height)
+	}
+	if state.Balances[miner] != expectedBalance {
+		t.Fatalf("expected miner balance %d, got %d", expectedBalance, state.Balances[miner])
+	}
+	if !state.TotalWork().Eq(expectedWork) {
+		t.Fatalf("expected the total work %s, got %s", expectedWork.Dec(), state.TotalWork().Dec())
+	}
+}
+
+// mineTestBlock mines a block with the given Txns on top of the given State
+func mineTestBlock(t *testing.T, s *State, miner common.Address, txns ...SignedTxn) Block {
+	return mineTestBlockAt(t, s, s.LatestBlock().Header.Time+TargetBlockTime, miner, txns...)
+}
+
+// mineTestBlockAt mines a block with the given time and Txns on top of the given State
+func mineTestBlockAt(t *testing.T, s *State, time uint64, miner common.Address, txns ...SignedTxn) Block {
+	txns, err := s.AddCoinbaseTxn(miner, txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txns == nil {
+		txns = []SignedTxn{}
+	}
+
+	txRoot, err := s.TxRoot(txns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Blocks with invalid Txns have no state root, they are rejected anyway
+	stateRoot, _ := s.NextStateRoot(miner, txns)
+
+	for nonce := uint32(0); ; nonce++ {
+		block := NewBlock(s.NextBlockHeight(), s.LatestBlockHash(), time, nonce, miner, txns)
+		block.Header.Version = s.EncodingVersion()
+		block.Header.Difficulty = s.NextDifficulty(miner, time)
+		block.Header.TxRoot = txRoot
+		block.Header.StateRoot = stateRoot
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if block.Header.IsHashValid(hash) {
+			return block
+		}
+	}
+}
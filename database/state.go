@@ -1,14 +1,13 @@
 package database
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
 	"log"
-	"os"
+	"math"
 	"reflect"
-	"sort"
 )
 
 const (
@@ -18,16 +17,36 @@ const (
 )
 
 type State struct {
-	Balances        map[common.Address]uint
+	Balances        map[common.Address]Amount
 	AccountNonces   map[common.Address]uint
-	dbFile          *os.File
+	store           BlockStore
+	indexes         *chainIndexes
 	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
-	forkOIP1        uint64
-
-	HeightCache map[uint64]int64
-	HashCache   map[string]int64
+	// totalWork is the work accumulated by the main chain up to the latest block, see BlockHeader.Work
+	totalWork uint256.Int
+	// recentTimes holds the times of the last MedianTimeBlocks blocks, oldest first
+	recentTimes []uint64
+	// recentSealers holds the sealers of the last blocks that can't seal the next one, see Consensus
+	recentSealers []common.Address
+	chainConfig   ChainConfig
+	chainID       string
+
+	snapshotDir      string
+	snapshotInterval uint64
+	pruneKeep        uint64
+
+	genesisBalances map[common.Address]Amount
+	rewards         blockRewards
+	// sideBlocks holds the recent blocks of the competing branches, see ImportBlock
+	sideBlocks map[Hash]Block
+	// orphans holds the blocks received before their parent, see ImportBlock
+	orphans *orphanPool
+	// checkpointed holds the blocks leading to the latest checkpoint block, see TrustCheckpointedBranch
+	checkpointed map[Hash]struct{}
+	clock        Clock
+	consensus    Consensus
 }
 
 func (s *State) LatestBlockHash() Hash {
@@ -38,6 +57,11 @@ func (s *State) LatestBlock() Block {
 	return s.latestBlock
 }
 
+// TotalWork returns the work accumulated by the main chain up to the latest block
+func (s *State) TotalWork() *uint256.Int {
+	return new(uint256.Int).Set(&s.totalWork)
+}
+
 func (s *State) NextBlockHeight() uint64 {
 	if !s.hasGenesisBlock {
 		return uint64(0)
@@ -49,11 +73,45 @@ func (s *State) GetNextAccountNonce(account common.Address) uint {
 	return s.AccountNonces[account] + 1
 }
 
-func (s *State) IsForkOIP1() bool {
-	return s.NextBlockHeight() >= s.forkOIP1
+// TxRoot returns the Txn root of the next block with the Txns,
+// empty prior to OIP-5 when blocks don't commit to a Txn root
+func (s *State) TxRoot(txns []SignedTxn) (Hash, error) {
+	if !s.Rules().IsOIP5 {
+		return Hash{}, nil
+	}
+	return ComputeTxRoot(txns)
+}
+
+// ChainID returns the ID of the chain from the genesis
+func (s *State) ChainID() string {
+	return s.chainID
+}
+
+// TxnChainID returns the chain ID the Txns of the next block must be signed for, empty prior to OIP-10
+func (s *State) TxnChainID() string {
+	if !s.Rules().IsOIP10 {
+		return ""
+	}
+	return s.chainID
+}
+
+// EncodingVersion returns the encoding version required for the next block and its Txns
+func (s *State) EncodingVersion() uint8 {
+	if s.Rules().IsOIP2 {
+		return VersionRLP
+	}
+	return VersionLegacyJSON
 }
 
 func NewStateFromDisk(dataDir string) (*State, error) {
+	return NewStateFromDiskWithOptions(dataDir, DefaultOptions())
+}
+
+func NewStateFromDiskWithOptions(dataDir string, opts Options) (*State, error) {
+	if opts.Prune > 0 && opts.SnapshotInterval == 0 {
+		return nil, fmt.Errorf("pruning requires State snapshots, the snapshot interval can't be 0")
+	}
+
 	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
 	if err != nil {
 		return nil, err
@@ -63,76 +121,155 @@ func NewStateFromDisk(dataDir string) (*State, error) {
 	if err != nil {
 		return nil, err
 	}
+	chainConfig, err := NewChainConfig(genesis.Forks, genesis.BlockLimits, genesis.Checkpoints)
+	if err != nil {
+		return nil, err
+	}
 
-	balances := make(map[common.Address]uint)
+	balances := make(map[common.Address]Amount)
 	for account, balance := range genesis.Balances {
 		balances[account] = balance
 	}
 
 	accountNonces := make(map[common.Address]uint)
 
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_APPEND|os.O_RDWR, 0600)
+	rewards, err := newBlockRewards(genesis.RewardSchedule, genesis.Balances)
+	if err != nil {
+		return nil, err
+	}
+
+	consensus, err := newConsensus(genesis, chainConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var clock Clock = systemClock{}
+	if opts.Clock != nil {
+		clock = opts.Clock
+	}
+
+	store, err := openBlockStore(dataDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := openChainIndexes(getIndexesDirPath(dataDir), chainConfig, rewards)
 	if err != nil {
+		store.Close()
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(f)
 	state := &State{
 		balances,
 		accountNonces,
-		f,
+		store,
+		indexes,
 		Block{},
 		Hash{},
 		false,
-		genesis.ForkOIP1,
-		map[uint64]int64{},
-		map[string]int64{},
+		uint256.Int{},
+		nil,
+		nil,
+		chainConfig,
+		genesis.ChainID,
+		getSnapshotsDirPath(dataDir),
+		opts.SnapshotInterval,
+		opts.Prune,
+		genesis.Balances,
+		rewards,
+		make(map[Hash]Block),
+		newOrphanPool(),
+		make(map[Hash]struct{}),
+		clock,
+		consensus,
 	}
 
-	filePos := int64(0)
-	//loop over each of the txn line in the blocks.db file
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	hasSnapshot, err := state.loadLatestSnapshot(math.MaxUint64)
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-		blockFsJson := scanner.Bytes()
-		var blockFs BlockFS
-		err = json.Unmarshal(blockFsJson, &blockFs)
-		if err != nil {
-			return nil, err
-		}
+	isTrusted := false
+	if vs, ok := store.(verifiedStore); ok {
+		isTrusted = vs.isVerified()
+	}
 
-		err = applyBlock(blockFs.Value, state)
-		if err != nil {
-			return nil, err
-		}
+	// replay the stored blocks after the snapshot to rebuild the balances
+	fromHeight := state.NextBlockHeight()
+	if hasSnapshot {
+		log.Printf("Loaded State snapshot at height %d\n", state.latestBlock.Header.Height)
+	}
 
-		state.latestBlockHash = blockFs.Key
-		state.latestBlock = blockFs.Value
-		state.hasGenesisBlock = true
+	err = state.replay(fromHeight, math.MaxUint64, isTrusted)
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-		// Set caches
-		state.HashCache[blockFs.Key.Hex()] = filePos
-		state.HeightCache[blockFs.Value.Header.Height] = filePos
-		filePos += int64(len(blockFsJson)) + 1
+	err = indexes.catchUp(store)
+	if err != nil {
+		log.Printf("ERROR: unable to index the stored blocks: %s\n", err)
 	}
 	return state, nil
 }
 
+// replay applies the stored blocks with from <= height <= to on top of the State
+func (s *State) replay(from, to uint64, isTrusted bool) error {
+	return s.store.Iterate(from, to, func(blockFs BlockFS) error {
+		var err error
+		if isTrusted {
+			err = applyStoredBlock(blockFs.Value, s)
+		} else {
+			err = applyBlock(blockFs.Value, s)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.latestBlockHash = blockFs.Key
+		s.latestBlock = blockFs.Value
+		s.hasGenesisBlock = true
+		return nil
+	})
+}
+
 func (s *State) apply(txn Txn) error {
 	if txn.IsReward() {
-		s.Balances[txn.To] += txn.Value
-		return nil
+		return s.credit(txn.To, txn.Value)
 	}
+	return s.transfer(txn.From, txn.To, txn.Value, txn.Value)
+}
 
-	if txn.Value > s.Balances[txn.From] {
-		return fmt.Errorf("account %s has insufficient balance for %d", txn.From, txn.Value)
+// credit adds the amount to the balance of the account
+func (s *State) credit(account common.Address, amount Amount) error {
+	balance, err := s.Balances[account].Add(amount)
+	if err != nil {
+		return fmt.Errorf("balance of account %s overflows: %s", account, err)
 	}
+	s.Balances[account] = balance
+	return nil
+}
 
-	s.Balances[txn.From] -= txn.Value
-	s.Balances[txn.To] += txn.Value
+// transfer debits the cost from the sender and credits the value to the receiver,
+// both balances are left untouched when either doesn't fit
+func (s *State) transfer(from, to common.Address, cost, value Amount) error {
+	fromBalance, err := s.Balances[from].Sub(cost)
+	if err != nil {
+		return fmt.Errorf("account %s has insufficient balance for %s", from, value)
+	}
 
+	toBalance := s.Balances[to]
+	if to == from {
+		toBalance = fromBalance
+	}
+	toBalance, err = toBalance.Add(value)
+	if err != nil {
+		return fmt.Errorf("balance of account %s overflows: %s", to, err)
+	}
+
+	s.Balances[from] = fromBalance
+	s.Balances[to] = toBalance
 	return nil
 }
 
@@ -141,9 +278,17 @@ func (s *State) Copy() State {
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
-	c.Balances = make(map[common.Address]uint)
+	c.totalWork = s.totalWork
+	c.Balances = make(map[common.Address]Amount)
 	c.AccountNonces = make(map[common.Address]uint)
-	c.forkOIP1 = s.forkOIP1
+	c.chainConfig = s.chainConfig
+	c.chainID = s.chainID
+	c.recentTimes = s.recentTimes
+	c.recentSealers = s.recentSealers
+	c.consensus = s.consensus
+	c.clock = s.clock
+	c.rewards = s.rewards
+	c.checkpointed = s.checkpointed
 
 	for acct, balance := range s.Balances {
 		c.Balances[acct] = balance
@@ -157,9 +302,14 @@ func (s *State) Copy() State {
 }
 
 func (s *State) AddBlock(b Block) (Hash, error) {
+	err := s.validateFutureBlockTime(b)
+	if err != nil {
+		return Hash{}, err
+	}
+
 	pendingState := s.Copy()
 
-	err := applyBlock(b, &pendingState)
+	err = applyBlock(b, &pendingState)
 	if err != nil {
 		return Hash{}, err
 	}
@@ -178,24 +328,38 @@ func (s *State) AddBlock(b Block) (Hash, error) {
 	log.Println("Saving new Block to disk:")
 	log.Printf("\t%s\n", blockFsJson)
 
-	// get file position for cache
-	fs, _ := s.dbFile.Stat()
-	filePos := fs.Size() + 1
-
-	_, err = s.dbFile.Write(append(blockFsJson, '\n'))
+	err = s.store.Append(blockFs)
 	if err != nil {
 		return Hash{}, err
 	}
 
 	s.Balances = pendingState.Balances
 	s.AccountNonces = pendingState.AccountNonces
+	s.recentTimes = pendingState.recentTimes
+	s.recentSealers = pendingState.recentSealers
 	s.latestBlockHash = blockHash
 	s.latestBlock = b
 	s.hasGenesisBlock = true
+	s.totalWork = pendingState.totalWork
+	delete(s.checkpointed, blockHash)
 
-	// Set search caches
-	s.HashCache[blockFs.Key.Hex()] = filePos
-	s.HeightCache[blockFs.Value.Header.Height] = filePos
+	// The block is already saved, the indexes catch up with the store on the next start
+	err = s.indexes.indexBlock(blockFs)
+	if err != nil {
+		log.Printf("ERROR: unable to index block %s: %s\n", blockHash.Hex(), err)
+	}
+
+	if s.snapshotInterval > 0 && b.Header.Height%s.snapshotInterval == 0 {
+		err = s.writeSnapshot()
+		if err != nil {
+			log.Printf("ERROR: unable to save State snapshot: %s\n", err)
+		}
+	}
+
+	err = s.pruneBlocks()
+	if err != nil {
+		log.Printf("ERROR: unable to prune blocks: %s\n", err)
+	}
 
 	return blockHash, nil
 }
@@ -211,12 +375,29 @@ func (s *State) AddBlocks(blocks []Block) error {
 }
 
 func (s *State) Close() {
-	s.dbFile.Close()
+	s.store.Close()
+	s.indexes.Close()
 }
 
 // applyBlock verifies if block can be added to the blockchain.
 // Block metadata are verified as well as transactions within (sufficient balances, etc).
+// The Txn signatures of the blocks leading to the latest checkpoint block are not recovered, see TrustCheckpointedBranch.
 func applyBlock(b Block, s *State) error {
+	hash, err := b.Hash()
+	if err != nil {
+		return err
+	}
+	_, isCheckpointed := s.checkpointed[hash]
+	return validateAndApplyBlock(b, s, !isCheckpointed)
+}
+
+// applyStoredBlock re-applies a block read from a verified local store.
+// The block was fully validated before being saved, so the Txn signatures are not recovered again.
+func applyStoredBlock(b Block, s *State) error {
+	return validateAndApplyBlock(b, s, false)
+}
+
+func validateAndApplyBlock(b Block, s *State, verifySigs bool) error {
 	nextExpectedBlockHeight := s.latestBlock.Header.Height + 1
 
 	// validate that the next block number increases by 1
@@ -229,39 +410,140 @@ func applyBlock(b Block, s *State) error {
 		return fmt.Errorf("next block parent hash must be %x not %x", s.latestBlockHash, b.Header.Parent)
 	}
 
+	if b.Header.Version != s.EncodingVersion() {
+		return fmt.Errorf("block at height %d must use encoding version %d not %d", b.Header.Height, s.EncodingVersion(), b.Header.Version)
+	}
+
+	err := s.validateBlockTime(b)
+	if err != nil {
+		return err
+	}
+
+	err = s.chainConfig.validateBlockLimits(b)
+	if err != nil {
+		return err
+	}
+
+	err = s.consensus.VerifyHeader(s, b.Header)
+	if err != nil {
+		return err
+	}
+
 	hash, err := b.Hash()
 	if err != nil {
 		return err
 	}
 
-	if !IsBlockHashValid(hash) {
-		return fmt.Errorf("invalid block hash %x", hash)
+	err = s.chainConfig.ValidateCheckpoint(b.Header.Height, hash)
+	if err != nil {
+		return err
 	}
 
-	err = applyTxns(b.Txns, s)
+	err = s.consensus.VerifySeal(b, hash)
 	if err != nil {
 		return err
 	}
 
-	// Credit the block reward and the fees from the transactions to the miner
-	s.Balances[b.Header.Miner] += Reward
-	if s.IsForkOIP1() {
-		s.Balances[b.Header.Miner] += b.GasReward()
-	} else {
-		s.Balances[b.Header.Miner] += uint(len(b.Txns)) * TxnFee
+	txRoot, err := s.TxRoot(b.Txns)
+	if err != nil {
+		return err
+	}
+	if b.Header.TxRoot != txRoot {
+		return fmt.Errorf("block at height %d must have Txn root %x not %x", b.Header.Height, txRoot, b.Header.TxRoot)
 	}
+
+	err = applyBlockTxns(b.Txns, b.Header.Miner, s, verifySigs)
+	if err != nil {
+		return err
+	}
+
+	err = validateStateRoot(b, s)
+	if err != nil {
+		return err
+	}
+
+	s.addRecentTime(b.Header.Time)
+	s.consensus.Finalize(s, b.Header)
+	s.totalWork.Add(&s.totalWork, uint256.NewInt(b.Header.Work()))
 	return nil
 }
 
-func ApplyTxn(txn SignedTxn, s *State) error {
-	// Verify the TXN was not forged
-	ok, err := txn.IsAuthentic()
+// applyBlockTxns applies the Txns of the next block and credits the block reward and the fees to the miner.
+// Since OIP-11 the block starts with the coinbase Txn crediting them.
+func applyBlockTxns(txns []SignedTxn, miner common.Address, s *State, verifySigs bool) error {
+	rules := s.Rules()
+	coinbase, transfers := splitCoinbase(txns, rules)
+	if rules.IsOIP11 && coinbase == nil {
+		return fmt.Errorf("block at height %d must start with its coinbase Txn", rules.Height)
+	}
+
+	err := applyTxns(transfers, s, verifySigs)
+	if err != nil {
+		return err
+	}
+
+	value, err := s.blockRewardAndFees(Block{Txns: txns}, rules)
 	if err != nil {
 		return err
 	}
+	if coinbase != nil {
+		err = validateCoinbase(*coinbase, miner, value, s)
+		if err != nil {
+			return err
+		}
+	}
+	return s.credit(miner, value)
+}
+
+// blockRewardAndFees returns the block reward plus the Txn fees of the block credited to its miner
+func (s *State) blockRewardAndFees(b Block, rules Rules) (Amount, error) {
+	fees, err := b.Fees(rules)
+	if err != nil {
+		return Amount{}, err
+	}
+	reward, err := s.rewards.reward(rules.Height)
+	if err != nil {
+		return Amount{}, err
+	}
+	return reward.Add(fees)
+}
+
+// validateStateRoot validates the state root of the block against the State the block was applied to
+func validateStateRoot(b Block, s *State) error {
+	stateRoot := Hash{}
+	if s.Rules().IsOIP6 {
+		var err error
+		stateRoot, err = s.stateRoot()
+		if err != nil {
+			return err
+		}
+	}
 
-	if !ok {
-		return fmt.Errorf("forged TXN, Sender %s was forged", txn.From.String())
+	if b.Header.StateRoot != stateRoot {
+		return fmt.Errorf("block at height %d must have state root %x not %x", b.Header.Height, stateRoot, b.Header.StateRoot)
+	}
+	return nil
+}
+
+func ApplyTxn(txn SignedTxn, s *State) error {
+	return applyTxn(txn, s, true)
+}
+
+func applyTxn(txn SignedTxn, s *State, verifySig bool) error {
+	if txn.Version != s.EncodingVersion() {
+		return fmt.Errorf("invalid Txn, encoding version must be %d not %d", s.EncodingVersion(), txn.Version)
+	}
+
+	// Verify the TXN was not forged
+	if verifySig {
+		ok, err := txn.IsAuthentic()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("forged TXN, Sender %s was forged", txn.From.String())
+		}
 	}
 
 	expectedNonce := s.GetNextAccountNonce(txn.From)
@@ -274,38 +556,57 @@ func ApplyTxn(txn SignedTxn, s *State) error {
 		)
 	}
 
-	if s.IsForkOIP1() {
+	rules := s.Rules()
+	if txn.ChainID != s.TxnChainID() {
+		if !rules.IsOIP10 {
+			return fmt.Errorf("invalid Txn, the chain ID can't be populated before the OIP-10 fork")
+		}
+		return fmt.Errorf("invalid Txn, signed for chain %q not %q", txn.ChainID, s.chainID)
+	}
+
+	if rules.IsOIP1 {
 		if txn.Gas != TxnGas {
 			return fmt.Errorf("insufficient Txn gas, requires %d got %d", TxnGas, txn.Gas)
 		}
-		if txn.GasPrice < DefaultGasPrice {
+		if txn.GasPrice.Cmp(NewAmount(DefaultGasPrice)) < 0 {
 			return fmt.Errorf("insufficient Txn gas price, requires at least %d", DefaultGasPrice)
 		}
 	} else {
 		// Prior to OIP1, s signed Txn must not populate gas and gasPrice field to prevent
 		// consensus from crashing
-		if txn.Gas != 0 || txn.GasPrice != 0 {
+		if txn.Gas != 0 || !txn.GasPrice.IsZero() {
 			return fmt.Errorf("invalid Txn, Gas and GasPrice cannot be populated before OIP1 fork")
 		}
 	}
 
-	if txn.TotalCost(s.IsForkOIP1()) > s.Balances[txn.From] {
-		return fmt.Errorf("account %s has insufficient balance for %d", txn.From, txn.Value)
+	cost, err := txn.TotalCost(rules)
+	if err != nil {
+		return err
+	}
+	err = s.transfer(txn.From, txn.To, cost, txn.Value)
+	if err != nil {
+		return err
 	}
-
-	s.Balances[txn.From] -= txn.TotalCost(s.IsForkOIP1())
-	s.Balances[txn.To] += txn.Value
 	s.AccountNonces[txn.From] = txn.Nonce
 
 	return nil
 }
 
-func applyTxns(txns []SignedTxn, s *State) error {
-	sort.Slice(txns, func(i, j int) bool {
-		return txns[i].Time < txns[j].Time
-	})
-	for _, txn := range txns {
-		err := ApplyTxn(txn, s)
+func applyTxns(txns []SignedTxn, s *State, verifySigs bool) error {
+	// Since OIP-9 the Txns are applied in the order of the block, which must be the canonical one
+	ordered := txns
+	if s.Rules().IsOIP9 {
+		err := validateTxnOrder(txns)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Sort a copy, the block keeps the Txns in the order its hash and TxRoot commit to
+		ordered = sortTxns(txns)
+	}
+
+	for _, txn := range ordered {
+		err := applyTxn(txn, s, verifySigs)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// AccountState is the balance and nonce of an account committed to by the state root, see OIP-6
+type AccountState struct {
+	Balance Amount `json:"balance"`
+	Nonce   uint   `json:"nonce"`
+}
+
+// AccountProof proves the state of an account after the block with the header was applied.
+// It's verified with the block header alone.
+type AccountProof struct {
+	Account   common.Address  `json:"account"`
+	State     AccountState    `json:"state"`
+	BlockHash Hash            `json:"block_hash"`
+	Header    BlockHeader     `json:"header"`
+	Proof     []hexutil.Bytes `json:"proof"`
+}
+
+// accountTrieKey hashes the address so the accounts spread evenly in the trie
+func accountTrieKey(account common.Address) []byte {
+	return crypto.Keccak256(account.Bytes())
+}
+
+// accountTrie returns the Merkle Patricia trie of the accounts. Accounts without balance
+// and nonce are left out, they are the same as accounts never seen.
+func (s *State) accountTrie() (*trie.Trie, error) {
+	t := trie.NewEmpty(nil)
+
+	accounts := make(map[common.Address]AccountState)
+	for account, balance := range s.Balances {
+		accounts[account] = AccountState{balance, s.AccountNonces[account]}
+	}
+	for account, nonce := range s.AccountNonces {
+		accounts[account] = AccountState{s.Balances[account], nonce}
+	}
+
+	for account, state := range accounts {
+		if state.Balance.IsZero() && state.Nonce == 0 {
+			continue
+		}
+
+		value, err := rlp.EncodeToBytes(state)
+		if err != nil {
+			return nil, err
+		}
+		err = t.Update(accountTrieKey(account), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// stateRoot returns the root of the trie of the current balances and nonces
+func (s *State) stateRoot() (Hash, error) {
+	t, err := s.accountTrie()
+	if err != nil {
+		return Hash{}, err
+	}
+	return Hash(t.Hash()), nil
+}
+
+// NextStateRoot returns the state root of the next block mined by the miner with the Txns,
+// empty prior to OIP-6 when blocks don't commit to a state root
+func (s *State) NextStateRoot(miner common.Address, txns []SignedTxn) (Hash, error) {
+	if !s.Rules().IsOIP6 {
+		return Hash{}, nil
+	}
+
+	pendingState := s.Copy()
+	err := applyBlockTxns(txns, miner, &pendingState, true)
+	if err != nil {
+		return Hash{}, err
+	}
+	return pendingState.stateRoot()
+}
+
+// GetAccountProof returns the Merkle proof of the account state at the latest block. Only the
+// blocks mined since OIP-6 commit to a state root.
+func (s *State) GetAccountProof(account common.Address) (AccountProof, error) {
+	if s.latestBlock.Header.StateRoot.IsEmpty() {
+		return AccountProof{}, fmt.Errorf("block at height %d was mined before OIP-6 and has no state root", s.latestBlock.Header.Height)
+	}
+
+	t, err := s.accountTrie()
+	if err != nil {
+		return AccountProof{}, err
+	}
+	if Hash(t.Hash()) != s.latestBlock.Header.StateRoot {
+		return AccountProof{}, fmt.Errorf("the State doesn't match the state root of block %s", s.latestBlockHash.Hex())
+	}
+
+	nodes := memorydb.New()
+	err = t.Prove(accountTrieKey(account), nodes)
+	if err != nil {
+		return AccountProof{}, err
+	}
+
+	proof := make([]hexutil.Bytes, 0)
+	it := nodes.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		proof = append(proof, common.CopyBytes(it.Value()))
+	}
+
+	state := AccountState{s.Balances[account], s.AccountNonces[account]}
+	return AccountProof{account, state, s.latestBlockHash, s.latestBlock.Header, proof}, nil
+}
+
+// VerifyAccountProof verifies that the account had the state of the proof in the block with the proof header.
+// The caller is left to check that the block hash belongs to the chain it follows.
+func VerifyAccountProof(p AccountProof) error {
+	if p.Header.StateRoot.IsEmpty() {
+		return fmt.Errorf("block at height %d has no state root", p.Header.Height)
+	}
+
+	hash, err := p.Header.Hash()
+	if err != nil {
+		return err
+	}
+	if hash != p.BlockHash {
+		return fmt.Errorf("the header hash %s doesn't match the block hash %s", hash.Hex(), p.BlockHash.Hex())
+	}
+
+	nodes := memorydb.New()
+	for _, node := range p.Proof {
+		err = nodes.Put(crypto.Keccak256(node), node)
+		if err != nil {
+			return err
+		}
+	}
+
+	value, err := trie.VerifyProof(common.Hash(p.Header.StateRoot), accountTrieKey(p.Account), nodes)
+	if err != nil {
+		return fmt.Errorf("invalid proof of account %s: %s", p.Account, err)
+	}
+
+	// An account missing from the trie has no balance and nonce
+	var state AccountState
+	if value != nil {
+		err = rlp.DecodeBytes(value, &state)
+		if err != nil {
+			return err
+		}
+	}
+	if state != p.State {
+		return fmt.Errorf("account %s has the state %+v not %+v in block %s", p.Account, state, p.State, p.BlockHash.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccountProofs(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	receiver := NewAccount("0x0418A658C5874D2Fe181145B685d2e73D761865D")
+	dataDir := setupTestDataDir(t, map[common.Address]Amount{sender: NewAmount(1000)})
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	txn := newTestTxn(sender, receiver, NewAmount(10), 1, "")
+	_, err = state.AddBlock(mineTestBlock(t, state, miner, signTestTxn(t, txn, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LatestBlock().Header.StateRoot.IsEmpty() {
+		t.Fatal("blocks since the fork must have a state root")
+	}
+
+	unknown := NewAccount("0x0000000000000000000000000000000000000042")
+	for _, account := range []common.Address{sender, miner, receiver, unknown} {
+		proof, err := state.GetAccountProof(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.State != (AccountState{state.Balances[account], state.AccountNonces[account]}) {
+			t.Fatalf("expected the state of %s in the proof, got %+v", account, proof.State)
+		}
+
+		// The proof goes through the API as JSON
+		proofJson, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received AccountProof
+		err = json.Unmarshal(proofJson, &received)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyAccountProof(received); err != nil {
+			t.Fatalf("the proof of %s should be valid: %s", account, err)
+		}
+
+		forged := received
+		forged.State.Balance, err = forged.State.Balance.Add(NewAmount(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyAccountProof(forged); err == nil {
+			t.Fatalf("the proof of %s with a changed balance must be rejected", account)
+		}
+	}
+
+	// The state root must match the State after the block
+	block := mineTestBlock(t, state, miner)
+	block.Header.StateRoot[0] ^= 0xff
+	for nonce := uint32(0); ; nonce++ {
+		block.Header.Nonce = nonce
+		if block.Header.IsHashValid(mustBlockHash(t, block)) {
+			break
+		}
+	}
+	_, err = state.AddBlock(block)
+	if err == nil || !strings.Contains(err.Error(), "state root") {
+		t.Fatalf("a block with another state root must be rejected, got error: %v", err)
+	}
+}
+
+func TestSnapshotsVerifiedByStateRoot(t *testing.T) {
+	dataDir := setupTestDataDir(t, nil)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+	opts := Options{BlockStore: BlockStoreFile, SnapshotInterval: 2}
+
+	state, err := NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = state.AddBlock(mineTestBlock(t, state, miner))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedBalance := state.Balances[miner]
+	state.Close()
+
+	// A snapshot of the stored chain with another State, and a valid checksum, must be rejected
+	path := getSnapshotFilePath(getSnapshotsDirPath(dataDir), 4)
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Balances[miner] = NewAmount(1_000_000)
+	checksum, err := snapshot.checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(snapshotFile{snapshot, checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = NewStateFromDiskWithOptions(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if state.Balances[miner] != expectedBalance {
+		t.Fatalf("expected miner balance %d, got %d", expectedBalance, state.Balances[miner])
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	BlockStoreFile    = "file"
+	BlockStoreLevelDB = "leveldb"
+)
+
+const (
+	// FsyncAlways flushes every new block to the disk before it's applied to the State
+	FsyncAlways = "always"
+	// FsyncInterval flushes new blocks to the disk at most once per fsyncInterval
+	FsyncInterval = "interval"
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever = "never"
+
+	fsyncInterval = time.Second
+)
+
+// BlockStore persists the blocks of the chain and looks them up by hash or height.
+type BlockStore interface {
+	// Append saves a new block at the tip of the chain
+	Append(blockFs BlockFS) error
+	GetByHash(hash Hash) (BlockFS, error)
+	GetByHeight(height uint64) (BlockFS, error)
+	// Iterate calls fn for every stored block with from <= height <= to, in ascending height order
+	Iterate(from, to uint64, fn func(BlockFS) error) error
+	Close() error
+}
+
+// verifiedStore is implemented by stores that check their content for consistency when opened.
+// Blocks of a verified store were validated before being saved and are trusted on replay.
+type verifiedStore interface {
+	isVerified() bool
+}
+
+// Options configures how the State persists the blockchain on disk
+type Options struct {
+	BlockStore string
+	// SnapshotInterval saves a State snapshot every N blocks, 0 disables snapshots
+	SnapshotInterval uint64
+	Fsync            string
+	// SegmentSize is the size in bytes of the segment files of the file store
+	SegmentSize int64
+	// Prune keeps only the last N blocks and deletes the older ones, 0 keeps the full history.
+	// The segments of the file store are sealed every N blocks so they can be deleted.
+	Prune uint64
+	// Clock checks new blocks are not too far in the future, the system clock when nil
+	Clock Clock
+}
+
+func DefaultOptions() Options {
+	return Options{BlockStore: BlockStoreFile, SnapshotInterval: DefaultSnapshotInterval, Fsync: FsyncAlways, SegmentSize: DefaultSegmentSize}
+}
+
+// segmentBlocks returns the number of blocks a segment of the file store is sealed at, 0 for no limit
+func (opts Options) segmentBlocks() int {
+	if opts.Prune > math.MaxInt32 {
+		return 0
+	}
+	return int(opts.Prune)
+}
+
+func openBlockStore(dataDir string, opts Options) (BlockStore, error) {
+	switch opts.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	case "":
+		opts.Fsync = FsyncAlways
+	default:
+		return nil, fmt.Errorf("unknown fsync policy '%s'", opts.Fsync)
+	}
+
+	switch opts.BlockStore {
+	case BlockStoreFile, "":
+		return openSegmentedBlockStore(getBlocksDirPath(dataDir), getBlocksDbFilePath(dataDir), opts.SegmentSize, opts.segmentBlocks(), opts.Fsync)
+	case BlockStoreLevelDB:
+		store, err := openLevelDBBlockStore(getBlocksLevelDBDirPath(dataDir), opts.Fsync)
+		if err != nil {
+			return nil, err
+		}
+
+		err = importBlocksDbIfEmpty(store, dataDir, opts)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown block store '%s'", opts.BlockStore)
+	}
+}
+
+// openReadOnlyBlockStore opens the block store of the data dir to read the blocks saved until now
+func openReadOnlyBlockStore(dataDir string, opts Options) (BlockStore, error) {
+	switch opts.BlockStore {
+	case BlockStoreFile, "":
+		if !hasFileBlocks(dataDir) {
+			return nil, fmt.Errorf("no blocks stored in %s", getDatabaseDirPath(dataDir))
+		}
+		if !fileExist(filepath.Join(getBlocksDirPath(dataDir), segmentManifestFileName)) {
+			return nil, fmt.Errorf("blocks.db must be migrated by starting the node first")
+		}
+		return openReadOnlySegmentedBlockStore(getBlocksDirPath(dataDir))
+	case BlockStoreLevelDB:
+		return openReadOnlyLevelDBBlockStore(getBlocksLevelDBDirPath(dataDir))
+	default:
+		return nil, fmt.Errorf("unknown block store '%s'", opts.BlockStore)
+	}
+}
+
+// importBlocksDbIfEmpty copies the blocks of the file store into a new and empty
+// leveldb store, so switching the store of a node does not require a re-sync
+func importBlocksDbIfEmpty(store *levelDBBlockStore, dataDir string, opts Options) error {
+	isEmpty, err := store.isEmpty()
+	if err != nil || !isEmpty || !hasFileBlocks(dataDir) {
+		return err
+	}
+
+	fileStore, err := openSegmentedBlockStore(getBlocksDirPath(dataDir), getBlocksDbFilePath(dataDir), opts.SegmentSize, opts.segmentBlocks(), FsyncNever)
+	if err != nil {
+		return err
+	}
+	defer fileStore.Close()
+
+	imported := 0
+	err = fileStore.Iterate(0, math.MaxUint64, func(blockFs BlockFS) error {
+		imported++
+		return store.Append(blockFs)
+	})
+	if err != nil {
+		return err
+	}
+
+	if imported > 0 {
+		log.Printf("Imported %d block(s) from the file store into the leveldb block store\n", imported)
+	}
+	return nil
+}
+
+// hasFileBlocks tells if blocks were ever saved by the file store
+func hasFileBlocks(dataDir string) bool {
+	if fileExist(filepath.Join(getBlocksDirPath(dataDir), segmentManifestFileName)) {
+		return true
+	}
+
+	stat, err := os.Stat(getBlocksDbFilePath(dataDir))
+	return err == nil && stat.Size() > 0
+}
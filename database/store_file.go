@@ -0,0 +1,416 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileBlockStore keeps the blocks as framed records in a single file, blocks.db or a segment.
+// The height and hash indexes point to the file position of each record and are persisted
+// next to the file, in blocks.db.idx for blocks.db
+type fileBlockStore struct {
+	mu       sync.RWMutex
+	f        *os.File
+	idxFile  *os.File
+	size     int64
+	heights  map[uint64]int64
+	hashes   map[Hash]int64
+	first    uint64
+	tip      uint64
+	verified bool
+
+	fsync    string
+	lastSync time.Time
+	// readOnly stores never repair or write the file, it may be written by a running node
+	readOnly bool
+}
+
+func getIndexFilePath(blocksDbPath string) string {
+	return blocksDbPath + ".idx"
+}
+
+// openReadOnlyFileBlockStore opens the blocks written until now without modifying the file
+func openReadOnlyFileBlockStore(path string) (*fileBlockStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	isLegacy, err := isJSONLinesBlocksDb(f)
+	if err != nil || isLegacy {
+		f.Close()
+		return nil, fmt.Errorf("%s must be migrated by starting the node first", path)
+	}
+
+	store := &fileBlockStore{
+		f:        f,
+		heights:  map[uint64]int64{},
+		hashes:   map[Hash]int64{},
+		fsync:    FsyncNever,
+		readOnly: true,
+	}
+
+	err = store.loadIndex(getIndexFilePath(path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func openFileBlockStore(path string, fsync string) (*fileBlockStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	isLegacy, err := isJSONLinesBlocksDb(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if isLegacy {
+		f.Close()
+		err = migrateJSONLinesBlocksDb(path)
+		if err != nil {
+			return nil, err
+		}
+
+		_ = os.Remove(getIndexFilePath(path))
+		f, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store := &fileBlockStore{
+		f:        f,
+		heights:  map[uint64]int64{},
+		hashes:   map[Hash]int64{},
+		fsync:    fsync,
+		lastSync: time.Now(),
+	}
+
+	err = store.loadIndex(getIndexFilePath(path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	store.idxFile, err = os.OpenFile(getIndexFilePath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// loadIndex loads the persisted index and checks it against blocks.db.
+// Entries that are corrupted or don't match the file anymore are dropped
+// and the blocks they should point to are re-indexed from blocks.db.
+func (fbs *fileBlockStore) loadIndex(idxPath string) error {
+	stat, err := fbs.f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := stat.Size()
+
+	entries, intact := readIndexFile(idxPath)
+	for len(entries) > 0 && entries[len(entries)-1].end() > fileSize {
+		entries = entries[:len(entries)-1]
+	}
+
+	// The last entry must point to the block it was written for
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		blockFs, err := fbs.readRecordAt(last.Offset, fileSize)
+		if err != nil || blockFs.Key != last.Hash || blockFs.Value.Header.Height != last.Height {
+			entries = nil
+		}
+	}
+
+	indexedSize := int64(0)
+	if len(entries) > 0 {
+		indexedSize = entries[len(entries)-1].end()
+	}
+
+	missing, validSize, err := fbs.scanEntries(indexedSize, fileSize)
+	if err != nil {
+		return err
+	}
+
+	if validSize < fileSize && !fbs.readOnly {
+		log.Printf("Dropping %d bytes of an incomplete block at the end of %s\n", fileSize-validSize, fbs.f.Name())
+		err = fbs.f.Truncate(validSize)
+		if err != nil {
+			return err
+		}
+		err = fbs.f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	fbs.verified = intact && len(missing) == 0
+	reused := len(entries)
+	entries = append(entries, missing...)
+
+	if !fbs.verified && !fbs.readOnly {
+		log.Printf("Rebuilding blocks index: %d entries reused, %d blocks re-indexed\n", reused, len(missing))
+
+		err = writeIndexFile(idxPath, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		fbs.index(e)
+	}
+	fbs.size = validSize
+	return nil
+}
+
+func (fbs *fileBlockStore) index(e indexEntry) {
+	if len(fbs.heights) == 0 {
+		fbs.first = e.Height
+	}
+	fbs.tip = e.Height
+	fbs.heights[e.Height] = e.Offset
+	fbs.hashes[e.Hash] = e.Offset
+}
+
+// scanEntries reads blocks.db between the from and to positions and returns the index entries of the blocks within.
+// A record cut short by a crash at the end of the file is not indexed and validSize stops right before it.
+func (fbs *fileBlockStore) scanEntries(from, to int64) (entries []indexEntry, validSize int64, err error) {
+	entries = make([]indexEntry, 0)
+
+	reader := bufio.NewReader(io.NewSectionReader(fbs.f, from, to-from))
+	filePos := from
+	for {
+		blockFs, size, err := readRecord(reader)
+		if err == io.EOF {
+			return entries, filePos, nil
+		}
+
+		isTorn := errors.Is(err, errTornRecord)
+		isCorruptTail := errors.Is(err, errCorruptRecord) && filePos+size >= to
+		if isTorn || isCorruptTail {
+			return entries, filePos, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s is corrupted at position %d: %s", fbs.f.Name(), filePos, err)
+		}
+
+		e := indexEntry{blockFs.Value.Header.Height, blockFs.Key, filePos, size}
+		entries = append(entries, e)
+		filePos = e.end()
+	}
+}
+
+func (fbs *fileBlockStore) Append(blockFs BlockFS) error {
+	if fbs.readOnly {
+		return fmt.Errorf("%s is opened read only", fbs.f.Name())
+	}
+
+	record, err := encodeRecord(blockFs)
+	if err != nil {
+		return err
+	}
+
+	fbs.mu.Lock()
+	defer fbs.mu.Unlock()
+
+	_, err = fbs.f.Write(record)
+	if err == nil {
+		err = fbs.sync()
+	}
+	if err != nil {
+		return fbs.discardTail(err)
+	}
+
+	e := indexEntry{blockFs.Value.Header.Height, blockFs.Key, fbs.size, int64(len(record))}
+	fbs.index(e)
+	fbs.size = e.end()
+
+	// The block is already saved, a missing index entry is rebuilt on the next start
+	_, err = fbs.idxFile.Write(e.encode())
+	if err != nil {
+		log.Printf("ERROR: unable to index block %s: %s\n", e.Hash.Hex(), err)
+	}
+	return nil
+}
+
+// discardTail truncates the bytes of a failed append so the next record is written right after the last
+// indexed one, the append error is returned
+func (fbs *fileBlockStore) discardTail(appendErr error) error {
+	err := fbs.f.Truncate(fbs.size)
+	if err == nil {
+		_, err = fbs.f.Seek(fbs.size, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("%s, unable to discard the partially written block: %s", appendErr, err)
+	}
+	return appendErr
+}
+
+// rewind deletes the blocks with a height of at least from, the index is rebuilt from the kept blocks
+func (fbs *fileBlockStore) rewind(from uint64) error {
+	if fbs.readOnly {
+		return fmt.Errorf("%s is opened read only", fbs.f.Name())
+	}
+
+	fbs.mu.Lock()
+	defer fbs.mu.Unlock()
+
+	if len(fbs.heights) == 0 || from > fbs.tip {
+		return nil
+	}
+
+	cut := int64(0)
+	if from > fbs.first {
+		cut = fbs.heights[from]
+	}
+
+	entries, _, err := fbs.scanEntries(0, cut)
+	if err != nil {
+		return err
+	}
+
+	err = fbs.f.Truncate(cut)
+	if err != nil {
+		return err
+	}
+	err = fbs.f.Sync()
+	if err != nil {
+		return err
+	}
+
+	idxPath := getIndexFilePath(fbs.f.Name())
+	fbs.idxFile.Close()
+	err = writeIndexFile(idxPath, entries)
+	if err != nil {
+		return err
+	}
+	fbs.idxFile, err = os.OpenFile(idxPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	fbs.heights = map[uint64]int64{}
+	fbs.hashes = map[Hash]int64{}
+	for _, e := range entries {
+		fbs.index(e)
+	}
+	fbs.size = cut
+	return nil
+}
+
+// sync flushes blocks.db to the disk according to the fsync policy
+func (fbs *fileBlockStore) sync() error {
+	switch fbs.fsync {
+	case FsyncNever:
+		return nil
+	case FsyncInterval:
+		if time.Since(fbs.lastSync) < fsyncInterval {
+			return nil
+		}
+	}
+
+	fbs.lastSync = time.Now()
+	return fbs.f.Sync()
+}
+
+func (fbs *fileBlockStore) GetByHash(hash Hash) (BlockFS, error) {
+	fbs.mu.RLock()
+	defer fbs.mu.RUnlock()
+
+	filePos, ok := fbs.hashes[hash]
+	if !ok {
+		return BlockFS{}, fmt.Errorf("invalid hash: %v", hash.Hex())
+	}
+	return fbs.readRecordAt(filePos, fbs.size)
+}
+
+func (fbs *fileBlockStore) GetByHeight(height uint64) (BlockFS, error) {
+	fbs.mu.RLock()
+	defer fbs.mu.RUnlock()
+
+	filePos, ok := fbs.heights[height]
+	if !ok {
+		return BlockFS{}, fmt.Errorf("invalid height: %v", height)
+	}
+	return fbs.readRecordAt(filePos, fbs.size)
+}
+
+func (fbs *fileBlockStore) Iterate(from, to uint64, fn func(BlockFS) error) error {
+	fbs.mu.RLock()
+	filePos, ok := fbs.heights[from]
+	size := fbs.size
+	fbs.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(fbs.f, filePos, size-filePos))
+	for {
+		blockFs, _, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if blockFs.Value.Header.Height > to {
+			return nil
+		}
+
+		err = fn(blockFs)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fbs *fileBlockStore) Close() error {
+	if fbs.idxFile != nil {
+		fbs.idxFile.Close()
+	}
+	if fbs.fsync != FsyncNever {
+		_ = fbs.f.Sync()
+	}
+	return fbs.f.Close()
+}
+
+// heightRange returns the heights of the first and last blocks of the file and their count
+func (fbs *fileBlockStore) heightRange() (first, tip uint64, count int) {
+	fbs.mu.RLock()
+	defer fbs.mu.RUnlock()
+	return fbs.first, fbs.tip, len(fbs.heights)
+}
+
+func (fbs *fileBlockStore) sizeOnDisk() int64 {
+	fbs.mu.RLock()
+	defer fbs.mu.RUnlock()
+	return fbs.size
+}
+
+func (fbs *fileBlockStore) isVerified() bool {
+	return fbs.verified
+}
+
+func (fbs *fileBlockStore) readRecordAt(filePos, size int64) (BlockFS, error) {
+	blockFs, _, err := readRecord(bufio.NewReader(io.NewSectionReader(fbs.f, filePos, size-filePos)))
+	if err == io.EOF {
+		return blockFs, io.ErrUnexpectedEOF
+	}
+	return blockFs, err
+}
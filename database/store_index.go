@@ -0,0 +1,99 @@
+package database
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+)
+
+// indexEntrySize height (8) + hash (32) + offset (8) + length (8) + crc32 (4)
+const indexEntrySize = 8 + 32 + 8 + 8 + 4
+
+// indexEntry locates one block of blocks.db. The entries are persisted in
+// blocks.db.idx so the file doesn't have to be scanned on every start.
+type indexEntry struct {
+	Height uint64
+	Hash   Hash
+	Offset int64
+	Length int64
+}
+
+func (e indexEntry) end() int64 {
+	return e.Offset + e.Length
+}
+
+func (e indexEntry) encode() []byte {
+	raw := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(raw[0:8], e.Height)
+	copy(raw[8:40], e.Hash[:])
+	binary.BigEndian.PutUint64(raw[40:48], uint64(e.Offset))
+	binary.BigEndian.PutUint64(raw[48:56], uint64(e.Length))
+	binary.BigEndian.PutUint32(raw[56:60], crc32.ChecksumIEEE(raw[:56]))
+	return raw
+}
+
+func decodeIndexEntry(raw []byte) (indexEntry, bool) {
+	if binary.BigEndian.Uint32(raw[56:60]) != crc32.ChecksumIEEE(raw[:56]) {
+		return indexEntry{}, false
+	}
+
+	e := indexEntry{
+		Height: binary.BigEndian.Uint64(raw[0:8]),
+		Offset: int64(binary.BigEndian.Uint64(raw[40:48])),
+		Length: int64(binary.BigEndian.Uint64(raw[48:56])),
+	}
+	copy(e.Hash[:], raw[8:40])
+	return e, true
+}
+
+// readIndexFile returns the longest run of intact and contiguous entries
+// of the index file. intact is false when some entries had to be dropped.
+func readIndexFile(path string) (entries []indexEntry, intact bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		// a missing index is only intact while blocks.db is empty
+		return nil, os.IsNotExist(err)
+	}
+
+	intact = len(content)%indexEntrySize == 0
+	end := int64(0)
+	for pos := 0; pos+indexEntrySize <= len(content); pos += indexEntrySize {
+		e, ok := decodeIndexEntry(content[pos : pos+indexEntrySize])
+		if !ok || e.Offset != end || (len(entries) > 0 && e.Height != entries[len(entries)-1].Height+1) {
+			return entries, false
+		}
+
+		entries = append(entries, e)
+		end = e.end()
+	}
+	return entries, intact
+}
+
+// writeIndexFile atomically replaces the index file with the given entries
+func writeIndexFile(path string, entries []indexEntry) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		_, err = f.Write(e.encode())
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
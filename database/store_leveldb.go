@@ -0,0 +1,224 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"syscall"
+	"time"
+)
+
+var (
+	levelDBBlockPrefix  = []byte("b") // b + hash -> BlockFS record
+	levelDBHeightPrefix = []byte("h") // h + big endian height -> hash
+	levelDBPrunedKey    = []byte("p") // p -> big endian height of the oldest stored block
+)
+
+// levelDBBlockStore keeps the blocks in an embedded key-value store so
+// lookups by hash or height don't need to scan the chain
+type levelDBBlockStore struct {
+	db    *leveldb.DB
+	fsync string
+
+	lastSync time.Time
+}
+
+func openLevelDBBlockStore(path string, fsync string) (*levelDBBlockStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBBlockStore{db, fsync, time.Now()}, nil
+}
+
+// openReadOnlyLevelDBBlockStore opens an existing store without writing to it.
+// leveldb locks its dir, so it fails while a node is running on the same store.
+func openReadOnlyLevelDBBlockStore(path string) (*levelDBBlockStore, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, fmt.Errorf("%s is locked by a running node, stop the node first", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBBlockStore{db, FsyncNever, time.Now()}, nil
+}
+
+func levelDBBlockKey(hash Hash) []byte {
+	return append(append([]byte{}, levelDBBlockPrefix...), hash[:]...)
+}
+
+func levelDBHeightKey(height uint64) []byte {
+	key := make([]byte, len(levelDBHeightPrefix)+8)
+	copy(key, levelDBHeightPrefix)
+	binary.BigEndian.PutUint64(key[len(levelDBHeightPrefix):], height)
+	return key
+}
+
+func (ls *levelDBBlockStore) Append(blockFs BlockFS) error {
+	record, err := encodeRecord(blockFs)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(levelDBBlockKey(blockFs.Key), record)
+	batch.Put(levelDBHeightKey(blockFs.Value.Header.Height), blockFs.Key[:])
+	return ls.db.Write(batch, &opt.WriteOptions{Sync: ls.shouldSync()})
+}
+
+// shouldSync tells if the next write must be flushed to the disk according to the fsync policy
+func (ls *levelDBBlockStore) shouldSync() bool {
+	switch ls.fsync {
+	case FsyncNever:
+		return false
+	case FsyncInterval:
+		if time.Since(ls.lastSync) < fsyncInterval {
+			return false
+		}
+	}
+
+	ls.lastSync = time.Now()
+	return true
+}
+
+func (ls *levelDBBlockStore) GetByHash(hash Hash) (BlockFS, error) {
+	var blockFs BlockFS
+
+	value, err := ls.db.Get(levelDBBlockKey(hash), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return blockFs, fmt.Errorf("invalid hash: %v", hash.Hex())
+	}
+	if err != nil {
+		return blockFs, err
+	}
+
+	// Blocks stored before OIP-2 are plain JSON
+	if len(value) > 0 && value[0] == '{' {
+		err = json.Unmarshal(value, &blockFs)
+		return blockFs, err
+	}
+
+	blockFs, _, err = readRecord(bytes.NewReader(value))
+	return blockFs, err
+}
+
+func (ls *levelDBBlockStore) GetByHeight(height uint64) (BlockFS, error) {
+	rawHash, err := ls.db.Get(levelDBHeightKey(height), nil)
+	if errors.Is(err, leveldb.ErrNotFound) && height < ls.prunedBelow() {
+		return BlockFS{}, ErrPruned{ls.prunedBelow()}
+	}
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return BlockFS{}, fmt.Errorf("invalid height: %v", height)
+	}
+	if err != nil {
+		return BlockFS{}, err
+	}
+
+	var hash Hash
+	copy(hash[:], rawHash)
+	return ls.GetByHash(hash)
+}
+
+func (ls *levelDBBlockStore) Iterate(from, to uint64, fn func(BlockFS) error) error {
+	heightRange := &util.Range{Start: levelDBHeightKey(from)}
+	if to < ^uint64(0) {
+		heightRange.Limit = levelDBHeightKey(to + 1)
+	} else {
+		heightRange.Limit = util.BytesPrefix(levelDBHeightPrefix).Limit
+	}
+
+	iter := ls.db.NewIterator(heightRange, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var hash Hash
+		copy(hash[:], iter.Value())
+
+		blockFs, err := ls.GetByHash(hash)
+		if err != nil {
+			return err
+		}
+
+		err = fn(blockFs)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// rewind deletes the blocks with a height of at least from
+func (ls *levelDBBlockStore) rewind(from uint64) error {
+	batch := new(leveldb.Batch)
+	iter := ls.db.NewIterator(&util.Range{Start: levelDBHeightKey(from), Limit: util.BytesPrefix(levelDBHeightPrefix).Limit}, nil)
+	for iter.Next() {
+		var hash Hash
+		copy(hash[:], iter.Value())
+
+		batch.Delete(append([]byte{}, iter.Key()...))
+		batch.Delete(levelDBBlockKey(hash))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	// The blocks of the new branch are only valid on top of the kept ones, always flush
+	return ls.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (ls *levelDBBlockStore) Close() error {
+	return ls.db.Close()
+}
+
+func (ls *levelDBBlockStore) isVerified() bool {
+	// leveldb checksums its blocks and recovers its journal on open
+	return true
+}
+
+func (ls *levelDBBlockStore) isEmpty() (bool, error) {
+	iter := ls.db.NewIterator(util.BytesPrefix(levelDBHeightPrefix), nil)
+	defer iter.Release()
+
+	return !iter.Next(), iter.Error()
+}
+
+func (ls *levelDBBlockStore) prune(below uint64) error {
+	from := ls.prunedBelow()
+	if below <= from {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	iter := ls.db.NewIterator(&util.Range{Start: levelDBHeightKey(from), Limit: levelDBHeightKey(below)}, nil)
+	for iter.Next() {
+		var hash Hash
+		copy(hash[:], iter.Value())
+
+		batch.Delete(append([]byte{}, iter.Key()...))
+		batch.Delete(levelDBBlockKey(hash))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	pruned := make([]byte, 8)
+	binary.BigEndian.PutUint64(pruned, below)
+	batch.Put(levelDBPrunedKey, pruned)
+	return ls.db.Write(batch, &opt.WriteOptions{Sync: ls.shouldSync()})
+}
+
+func (ls *levelDBBlockStore) prunedBelow() uint64 {
+	value, err := ls.db.Get(levelDBPrunedKey, nil)
+	if err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
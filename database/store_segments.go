@@ -0,0 +1,464 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultSegmentSize is the size a segment file grows to before a new one is started
+const DefaultSegmentSize = 64 << 20
+
+const segmentManifestFileName = "manifest.json"
+
+// blockSegment describes one segment file of the blocks dir.
+// The heights of the active segment are only known once it's sealed.
+type blockSegment struct {
+	Name        string `json:"name"`
+	FirstHeight uint64 `json:"first_height"`
+	LastHeight  uint64 `json:"last_height"`
+	Blocks      int    `json:"blocks"`
+	Size        int64  `json:"size"`
+	Sealed      bool   `json:"sealed"`
+	Pruned      bool   `json:"pruned,omitempty"`
+}
+
+type segmentManifest struct {
+	Segments []blockSegment `json:"segments"`
+}
+
+// segmentedBlockStore splits the blocks into segment files of about segmentSize bytes.
+// Every segment is a framed records file with its own index, and the manifest lists the
+// segments in height order. Sealed segments are never written again so they can be
+// archived, blocks of a segment missing from the blocks dir are reported as archived.
+type segmentedBlockStore struct {
+	mu          sync.RWMutex
+	dir         string
+	fsync       string
+	segmentSize int64
+	// segmentBlocks seals a segment once it holds that many blocks, 0 for no limit. Pruned
+	// nodes limit their segments to the blocks they keep, pruning deletes whole segments.
+	segmentBlocks int
+	manifest      segmentManifest
+	// segments holds the opened segments by manifest position, nil when archived
+	segments []*fileBlockStore
+}
+
+func getSegmentFileName(number int) string {
+	return fmt.Sprintf("segment-%06d.db", number)
+}
+
+func openSegmentedBlockStore(dir string, blocksDbPath string, segmentSize int64, segmentBlocks int, fsync string) (*segmentedBlockStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &segmentedBlockStore{dir: dir, fsync: fsync, segmentSize: segmentSize, segmentBlocks: segmentBlocks}
+
+	if fileExist(filepath.Join(dir, segmentManifestFileName)) {
+		err = store.readManifest()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = store.migrateBlocksDb(blocksDbPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, segment := range store.manifest.Segments {
+		path := filepath.Join(dir, segment.Name)
+		isActive := i == len(store.manifest.Segments)-1
+
+		if segment.Pruned {
+			store.segments = append(store.segments, nil)
+			continue
+		}
+		if segment.Sealed && !fileExist(path) {
+			log.Printf("Segment %s (heights %d..%d) is archived\n", segment.Name, segment.FirstHeight, segment.LastHeight)
+			store.segments = append(store.segments, nil)
+			continue
+		}
+		if isActive && !fileExist(path) {
+			err = writeEmptyBlocksDbToDisk(path)
+			if err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+
+		fbs, err := openFileBlockStore(path, fsync)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("unable to open segment %s: %s", segment.Name, err)
+		}
+		store.segments = append(store.segments, fbs)
+	}
+	return store, nil
+}
+
+// openReadOnlySegmentedBlockStore opens the segments written until now without modifying them
+func openReadOnlySegmentedBlockStore(dir string) (*segmentedBlockStore, error) {
+	store := &segmentedBlockStore{dir: dir, fsync: FsyncNever}
+	err := store.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range store.manifest.Segments {
+		path := filepath.Join(dir, segment.Name)
+		if segment.Pruned || !fileExist(path) {
+			store.segments = append(store.segments, nil)
+			continue
+		}
+
+		fbs, err := openReadOnlyFileBlockStore(path)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("unable to open segment %s: %s", segment.Name, err)
+		}
+		store.segments = append(store.segments, fbs)
+	}
+	return store, nil
+}
+
+func (ss *segmentedBlockStore) readManifest() error {
+	path := filepath.Join(ss.dir, segmentManifestFileName)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(content, &ss.manifest)
+	if err != nil {
+		return fmt.Errorf("unable to read segments manifest %s: %s", path, err)
+	}
+	return nil
+}
+
+// migrateBlocksDb turns the single blocks.db of older versions into the first segment
+func (ss *segmentedBlockStore) migrateBlocksDb(blocksDbPath string) error {
+	segment := blockSegment{Name: getSegmentFileName(0)}
+	segmentPath := filepath.Join(ss.dir, segment.Name)
+
+	if fileExist(blocksDbPath) {
+		// Frame a JSON lines blocks.db and rebuild its index before moving it
+		fbs, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+		if err != nil {
+			return err
+		}
+		count := len(fbs.heights)
+		fbs.Close()
+
+		err = os.Rename(blocksDbPath, segmentPath)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(getIndexFilePath(blocksDbPath), getIndexFilePath(segmentPath))
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			log.Printf("Moved %d block(s) of blocks.db into segment %s\n", count, segment.Name)
+		}
+	}
+
+	ss.manifest.Segments = []blockSegment{segment}
+	return ss.writeManifest()
+}
+
+// writeManifest atomically replaces the manifest of the blocks dir
+func (ss *segmentedBlockStore) writeManifest() error {
+	content, err := json.MarshalIndent(ss.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(ss.dir, segmentManifestFileName)
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (ss *segmentedBlockStore) active() *fileBlockStore {
+	return ss.segments[len(ss.segments)-1]
+}
+
+func (ss *segmentedBlockStore) Append(blockFs BlockFS) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	_, _, count := ss.active().heightRange()
+	if ss.active().sizeOnDisk() >= ss.segmentSize || (ss.segmentBlocks > 0 && count >= ss.segmentBlocks) {
+		err := ss.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	return ss.active().Append(blockFs)
+}
+
+// rotate seals the active segment and starts a new one
+func (ss *segmentedBlockStore) rotate() error {
+	last := len(ss.segments) - 1
+	first, tip, count := ss.segments[last].heightRange()
+
+	sealed := ss.manifest.Segments[last]
+	sealed.FirstHeight = first
+	sealed.LastHeight = tip
+	sealed.Blocks = count
+	sealed.Size = ss.segments[last].sizeOnDisk()
+	sealed.Sealed = true
+
+	segment := blockSegment{Name: getSegmentFileName(len(ss.manifest.Segments))}
+	path := filepath.Join(ss.dir, segment.Name)
+	err := writeEmptyBlocksDbToDisk(path)
+	if err != nil {
+		return err
+	}
+
+	// The segment file must exist before the manifest lists it
+	previous := ss.manifest.Segments
+	ss.manifest.Segments = append(append(previous[:last:last], sealed), segment)
+	err = ss.writeManifest()
+	if err != nil {
+		ss.manifest.Segments = previous
+		_ = os.Remove(path)
+		return err
+	}
+
+	fbs, err := openFileBlockStore(path, ss.fsync)
+	if err != nil {
+		ss.manifest.Segments = previous
+		if ss.writeManifest() == nil {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+	ss.segments = append(ss.segments, fbs)
+
+	log.Printf("Sealed segment %s (heights %d..%d), new blocks go to %s\n", sealed.Name, first, tip, segment.Name)
+	return nil
+}
+
+// segmentOf returns the position in the manifest of the segment holding the height
+func (ss *segmentedBlockStore) segmentOf(height uint64) int {
+	for i, segment := range ss.manifest.Segments {
+		if !segment.Sealed || height <= segment.LastHeight {
+			return i
+		}
+	}
+	return len(ss.manifest.Segments) - 1
+}
+
+func (ss *segmentedBlockStore) GetByHash(hash Hash) (BlockFS, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	for i := len(ss.segments) - 1; i >= 0; i-- {
+		if ss.segments[i] == nil {
+			continue
+		}
+		if blockFs, err := ss.segments[i].GetByHash(hash); err == nil {
+			return blockFs, nil
+		}
+	}
+	return BlockFS{}, fmt.Errorf("invalid hash: %v", hash.Hex())
+}
+
+func (ss *segmentedBlockStore) GetByHeight(height uint64) (BlockFS, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	i := ss.segmentOf(height)
+	if ss.manifest.Segments[i].Pruned {
+		return BlockFS{}, ErrPruned{ss.prunedBelowLocked()}
+	}
+	if ss.segments[i] == nil {
+		return BlockFS{}, fmt.Errorf("block at height %d is in the archived segment %s", height, ss.manifest.Segments[i].Name)
+	}
+	return ss.segments[i].GetByHeight(height)
+}
+
+func (ss *segmentedBlockStore) Iterate(from, to uint64, fn func(BlockFS) error) error {
+	ss.mu.RLock()
+	segments := append([]*fileBlockStore{}, ss.segments...)
+	manifest := append([]blockSegment{}, ss.manifest.Segments...)
+	ss.mu.RUnlock()
+
+	for i, segment := range manifest {
+		if segment.Sealed && segment.LastHeight < from {
+			continue
+		}
+		if segment.Sealed && segment.FirstHeight > to {
+			return nil
+		}
+		if segment.Pruned {
+			return ErrPruned{segment.LastHeight + 1}
+		}
+		if segments[i] == nil {
+			return fmt.Errorf("blocks from height %d are in the archived segment %s", segment.FirstHeight, segment.Name)
+		}
+
+		start := from
+		if segment.Sealed && start < segment.FirstHeight {
+			start = segment.FirstHeight
+		}
+		if !segment.Sealed {
+			first, _, count := segments[i].heightRange()
+			if count == 0 {
+				return nil
+			}
+			if start < first {
+				start = first
+			}
+		}
+
+		err := segments[i].Iterate(start, to, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune deletes the sealed segments holding only blocks below the height
+func (ss *segmentedBlockStore) prune(below uint64) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var paths []string
+	for i := range ss.manifest.Segments {
+		segment := &ss.manifest.Segments[i]
+		if !segment.Sealed || segment.LastHeight >= below {
+			break
+		}
+		if segment.Pruned {
+			continue
+		}
+
+		if ss.segments[i] != nil {
+			ss.segments[i].Close()
+			ss.segments[i] = nil
+		}
+		segment.Pruned = true
+
+		path := filepath.Join(ss.dir, segment.Name)
+		paths = append(paths, path, getIndexFilePath(path))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// Files are only deleted once the manifest doesn't need them anymore
+	err := ss.writeManifest()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewind deletes the blocks with a height of at least from. The segment holding the height
+// becomes the active segment again and the segments after it are deleted.
+func (ss *segmentedBlockStore) rewind(from uint64) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	i := ss.segmentOf(from)
+	if ss.manifest.Segments[i].Pruned {
+		return ErrPruned{ss.prunedBelowLocked()}
+	}
+	if ss.segments[i] == nil {
+		return fmt.Errorf("block at height %d is in the archived segment %s", from, ss.manifest.Segments[i].Name)
+	}
+
+	var paths []string
+	for j := len(ss.segments) - 1; j > i; j-- {
+		path := filepath.Join(ss.dir, ss.manifest.Segments[j].Name)
+		paths = append(paths, path, getIndexFilePath(path))
+	}
+
+	if len(paths) > 0 || ss.manifest.Segments[i].Sealed {
+		previous := ss.manifest.Segments
+		ss.manifest.Segments = append(previous[:i:i], blockSegment{Name: previous[i].Name})
+		err := ss.writeManifest()
+		if err != nil {
+			ss.manifest.Segments = previous
+			return err
+		}
+
+		for _, fbs := range ss.segments[i+1:] {
+			if fbs != nil {
+				fbs.Close()
+			}
+		}
+		ss.segments = ss.segments[:i+1]
+	}
+
+	// Files are only deleted once the manifest doesn't list them anymore
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ss.segments[i].rewind(from)
+}
+
+func (ss *segmentedBlockStore) prunedBelow() uint64 {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.prunedBelowLocked()
+}
+
+func (ss *segmentedBlockStore) prunedBelowLocked() uint64 {
+	below := uint64(0)
+	for _, segment := range ss.manifest.Segments {
+		if !segment.Pruned {
+			break
+		}
+		below = segment.LastHeight + 1
+	}
+	return below
+}
+
+func (ss *segmentedBlockStore) Close() error {
+	var err error
+	for _, fbs := range ss.segments {
+		if fbs == nil {
+			continue
+		}
+		if closeErr := fbs.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (ss *segmentedBlockStore) isVerified() bool {
+	for _, fbs := range ss.segments {
+		if fbs != nil && !fbs.isVerified() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,472 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlockStores(t *testing.T) {
+	stores := []string{BlockStoreFile, BlockStoreLevelDB}
+
+	for _, kind := range stores {
+		t.Run(kind, func(t *testing.T) {
+			dataDir := t.TempDir()
+			err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := openBlockStore(dataDir, Options{BlockStore: kind})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blocks := appendTestBlocks(t, store, 5)
+
+			blockFs, err := store.GetByHeight(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blockFs.Key != blocks[3].Key {
+				t.Fatalf("block at height 3 should have hash %s not %s", blocks[3].Key.Hex(), blockFs.Key.Hex())
+			}
+
+			blockFs, err = store.GetByHash(blocks[1].Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blockFs.Value.Header.Height != 1 {
+				t.Fatalf("block %s should be at height 1 not %d", blocks[1].Key.Hex(), blockFs.Value.Header.Height)
+			}
+
+			if _, err = store.GetByHeight(10); err == nil {
+				t.Fatal("getting a block above the tip should fail")
+			}
+
+			var heights []uint64
+			err = store.Iterate(2, 3, func(blockFs BlockFS) error {
+				heights = append(heights, blockFs.Value.Header.Height)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(heights) != 2 || heights[0] != 2 || heights[1] != 3 {
+				t.Fatalf("iterating heights 2..3 returned %v", heights)
+			}
+
+			// Blocks must still be there after re-opening the store
+			store.Close()
+			store, err = openBlockStore(dataDir, Options{BlockStore: kind})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			count := 0
+			err = store.Iterate(0, math.MaxUint64, func(blockFs BlockFS) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(blocks) {
+				t.Fatalf("expected %d blocks after re-opening the store, got %d", len(blocks), count)
+			}
+		})
+	}
+}
+
+func TestLevelDBBlockStoreImportsBlocksDb(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileStore, err := openBlockStore(dataDir, Options{BlockStore: BlockStoreFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := appendTestBlocks(t, fileStore, 3)
+	fileStore.Close()
+
+	store, err := openBlockStore(dataDir, Options{BlockStore: BlockStoreLevelDB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := os.Stat(filepath.Join(getDatabaseDirPath(dataDir), "blocks.ldb")); err != nil {
+		t.Fatal(err)
+	}
+
+	blockFs, err := store.GetByHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockFs.Key != blocks[2].Key {
+		t.Fatal("blocks.db was not imported into the leveldb store")
+	}
+}
+
+func appendTestBlocks(t *testing.T, store BlockStore, count int) []BlockFS {
+	blocks := make([]BlockFS, count)
+	parent := Hash{}
+
+	for i := 0; i < count; i++ {
+		block := NewBlock(uint64(i), parent, uint64(i), uint32(i), NewAccount(""), []SignedTxn{})
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks[i] = BlockFS{hash, block}
+		err = store.Append(blocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = hash
+	}
+	return blocks
+}
+
+func TestFileBlockStoreIndex(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocksDbPath := getBlocksDbFilePath(dataDir)
+
+	store, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := appendTestBlocks(t, store, 4)
+	store.Close()
+
+	reopen := func() *fileBlockStore {
+		store, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blockFs, err := store.GetByHash(blocks[3].Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blockFs.Value.Header.Height != 3 {
+			t.Fatalf("expected block at height 3, got %d", blockFs.Value.Header.Height)
+		}
+		return store
+	}
+
+	store = reopen()
+	if !store.isVerified() {
+		t.Fatal("an up to date index should be verified")
+	}
+	store.Close()
+
+	// Corrupt the 3rd entry, only the first 2 can be reused
+	idxPath := getIndexFilePath(blocksDbPath)
+	content, err := os.ReadFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[2*indexEntrySize+10] ^= 0xff
+	err = os.WriteFile(idxPath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopen()
+	if store.isVerified() {
+		t.Fatal("a corrupted index should be rebuilt")
+	}
+	store.Close()
+
+	// Stale index missing the last block
+	content, err = os.ReadFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(idxPath, content[:3*indexEntrySize], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = reopen()
+	store.Close()
+
+	store = reopen()
+	defer store.Close()
+	if !store.isVerified() {
+		t.Fatal("the rebuilt index should be verified on the next start")
+	}
+}
+
+func TestFileBlockStoreDropsTornRecord(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocksDbPath := getBlocksDbFilePath(dataDir)
+
+	store, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := appendTestBlocks(t, store, 3)
+	store.Close()
+
+	stat, err := os.Stat(blocksDbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of writing a 4th block
+	record, err := encodeRecord(blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(blocksDbPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(record[:len(record)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	store, err = openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	repaired, err := os.Stat(blocksDbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired.Size() != stat.Size() {
+		t.Fatalf("the incomplete record should be truncated, expected size %d got %d", stat.Size(), repaired.Size())
+	}
+
+	if _, err = store.GetByHeight(2); err != nil {
+		t.Fatal(err)
+	}
+
+	block := NewBlock(3, blocks[2].Key, 3, 3, NewAccount(""), []SignedTxn{})
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Append(BlockFS{hash, block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.GetByHash(hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBlockStoreDiscardsFailedAppend(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocksDbPath := getBlocksDbFilePath(dataDir)
+
+	store, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := appendTestBlocks(t, store, 3)
+
+	// Simulate a write of the 4th block failing halfway
+	record, err := encodeRecord(blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.f.Write(record[:len(record)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendErr := errors.New("no space left on device")
+	if err = store.discardTail(appendErr); err != appendErr {
+		t.Fatalf("expected the append error to be returned, got %v", err)
+	}
+
+	block := NewBlock(3, blocks[2].Key, 3, 3, NewAccount(""), []SignedTxn{})
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Append(BlockFS{hash, block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	blockFs, err := store.GetByHeight(3)
+	if err != nil || blockFs.Key != hash {
+		t.Fatalf("expected the block appended after the failed write at height 3, got %s: %v", blockFs.Key.Hex(), err)
+	}
+}
+
+func TestFileBlockStoreMigratesJSONLines(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocksDbPath := getBlocksDbFilePath(dataDir)
+
+	// blocks.db written as JSON lines, with the last line cut short
+	content := []byte{}
+	parent := Hash{}
+	for i := 0; i < 3; i++ {
+		block := NewBlock(uint64(i), parent, uint64(i), uint32(i), NewAccount(""), []SignedTxn{})
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blockFsJson, err := json.Marshal(BlockFS{hash, block})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, append(blockFsJson, '\n')...)
+		parent = hash
+	}
+	content = append(content, []byte(`{"hash":"00`)...)
+
+	err = os.WriteFile(blocksDbPath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openFileBlockStore(blocksDbPath, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	blockFs, err := store.GetByHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockFs.Key != parent {
+		t.Fatal("the last complete block was not migrated")
+	}
+}
+
+func TestSegmentedBlockStore(t *testing.T) {
+	dataDir := t.TempDir()
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks saved in blocks.db before segments existed
+	fileStore, err := openFileBlockStore(getBlocksDbFilePath(dataDir), FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := appendTestBlocks(t, fileStore, 2)
+	fileStore.Close()
+
+	// Every segment is sealed after its first block
+	opts := Options{BlockStore: BlockStoreFile, SegmentSize: 1}
+	store, err := openBlockStore(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExist(getBlocksDbFilePath(dataDir)) {
+		t.Fatal("blocks.db should be moved into the first segment")
+	}
+
+	// A block much bigger than a bufio.Scanner token
+	txn := NewTxn(NewAccount(""), NewAccount(""), 0, Amount{}, NewAmount(1), 1, strings.Repeat("x", 128*1024))
+	for i := 2; i < 5; i++ {
+		block := NewBlock(uint64(i), blocks[i-1].Key, uint64(i), uint32(i), NewAccount(""), []SignedTxn{NewSignedTxn(txn, nil)})
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, BlockFS{hash, block})
+		err = store.Append(blocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	manifest, err := os.ReadFile(filepath.Join(getBlocksDirPath(dataDir), segmentManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m segmentManifest
+	err = json.Unmarshal(manifest, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Segments) != 4 || !m.Segments[0].Sealed || m.Segments[0].LastHeight != 1 || m.Segments[3].Sealed {
+		t.Fatalf("unexpected segments manifest %s", manifest)
+	}
+
+	// Archive the first segment
+	archiveDir := t.TempDir()
+	for _, name := range []string{m.Segments[0].Name, getIndexFilePath(m.Segments[0].Name)} {
+		err = os.Rename(filepath.Join(getBlocksDirPath(dataDir), name), filepath.Join(archiveDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err = openBlockStore(dataDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err = store.GetByHeight(1); err == nil || !strings.Contains(err.Error(), "archived") {
+		t.Fatalf("a block of an archived segment should be reported as archived, got %v", err)
+	}
+
+	blockFs, err := store.GetByHash(blocks[4].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockFs.Value.Txns[0].Data != txn.Data {
+		t.Fatal("the data of a large block was not read back")
+	}
+
+	var heights []uint64
+	err = store.Iterate(2, math.MaxUint64, func(blockFs BlockFS) error {
+		heights = append(heights, blockFs.Value.Header.Height)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heights) != 3 || heights[0] != 2 || heights[2] != 4 {
+		t.Fatalf("iterating the segments from height 2 returned %v", heights)
+	}
+}
@@ -3,7 +3,6 @@ package database
 import (
 	"crypto/elliptic"
 	"crypto/sha256"
-	"encoding/json"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"time"
@@ -19,13 +18,17 @@ type Txn struct {
 	From common.Address `json:"from"`
 	To   common.Address `json:"to"`
 
-	Gas      uint `json:"gas"`
-	GasPrice uint `json:"gasPrice"`
+	Gas      uint   `json:"gas"`
+	GasPrice Amount `json:"gasPrice"`
 
-	Value uint   `json:"value"`
+	Value Amount `json:"value"`
 	Nonce uint   `json:"nonce"`
 	Data  string `json:"data"`
 	Time  uint64 `json:"time"`
+
+	Version uint8 `json:"version"`
+	// ChainID is the chain the Txn is signed for since OIP-10, it can't be replayed on another chain
+	ChainID string `json:"chain_id,omitempty"`
 }
 
 type SignedTxn struct {
@@ -33,85 +36,40 @@ type SignedTxn struct {
 	Sig []byte `json:"signature"`
 }
 
+// IsReward tells if the Txn is the coinbase Txn of a block, crediting the block reward and the Txn fees
+// to the miner since OIP-11. Nobody holds the key of the zero address, no signed Txn can be a coinbase.
 func (t Txn) IsReward() bool {
-	return t.Data == "reward"
+	return t.From == common.Address{} && t.Data == coinbaseData
 }
 
 func (t Txn) Hash() (Hash, error) {
-	txnJson, err := json.Marshal(t)
+	txnEncoded, err := t.Encode()
 	if err != nil {
 		return Hash{}, err
 	}
-	return sha256.Sum256(txnJson), nil
+	return sha256.Sum256(txnEncoded), nil
 }
 
+// Encode returns the canonical encoding of the Txn selected by its version
 func (t Txn) Encode() ([]byte, error) {
-	return json.Marshal(t)
-}
-
-func (t Txn) GasCost() uint {
-	return t.Gas * t.GasPrice
-}
-
-func (t Txn) TotalCost(isForkOIP1 bool) uint {
-	if isForkOIP1 {
-		return t.Value + t.GasCost()
-	}
-	return t.Value + TxnFee
+	return encodeTxn(t)
 }
 
-func (t Txn) MarshalJSON() ([]byte, error) {
-	// Prior OIP1
-	if t.Gas == 0 {
-		type legacyTxn struct {
-			From  common.Address `json:"from"`
-			To    common.Address `json:"to"`
-			Value uint           `json:"value"`
-			Nonce uint           `json:"nonce"`
-			Data  string         `json:"data"`
-			Time  uint64         `json:"time"`
-		}
-		return json.Marshal(legacyTxn{t.From, t.To, t.Value, t.Nonce, t.Data, t.Time})
-	}
-	type oip1Txn struct {
-		From     common.Address `json:"from"`
-		To       common.Address `json:"to"`
-		Gas      uint           `json:"gas"`
-		GasPrice uint           `json:"gasPrice"`
-		Value    uint           `json:"value"`
-		Nonce    uint           `json:"nonce"`
-		Data     string         `json:"data"`
-		Time     uint64         `json:"time"`
-	}
-	return json.Marshal(oip1Txn{t.From, t.To, t.Gas, t.GasPrice, t.Value, t.Nonce, t.Data, t.Time})
+func (t Txn) GasCost() (Amount, error) {
+	return t.GasPrice.MulUint(t.Gas)
 }
 
-func (s SignedTxn) MarshalJSON() ([]byte, error) {
-	// Prior OIP1
-	if s.Gas == 0 {
-		type legacyTxn struct {
-			From  common.Address `json:"from"`
-			To    common.Address `json:"to"`
-			Value uint           `json:"value"`
-			Nonce uint           `json:"nonce"`
-			Data  string         `json:"data"`
-			Time  uint64         `json:"time"`
-			Sig   []byte         `json:"signature"`
+// TotalCost returns the value of the Txn plus its fee under the rules of its block
+func (t Txn) TotalCost(rules Rules) (Amount, error) {
+	fee := NewAmount(uint64(TxnFee))
+	if rules.IsOIP1 {
+		var err error
+		fee, err = t.GasCost()
+		if err != nil {
+			return Amount{}, err
 		}
-		return json.Marshal(legacyTxn{s.From, s.To, s.Value, s.Nonce, s.Data, s.Time, s.Sig})
-	}
-	type oip1Txn struct {
-		From     common.Address `json:"from"`
-		To       common.Address `json:"to"`
-		Gas      uint           `json:"gas"`
-		GasPrice uint           `json:"gasPrice"`
-		Value    uint           `json:"value"`
-		Nonce    uint           `json:"nonce"`
-		Data     string         `json:"data"`
-		Time     uint64         `json:"time"`
-		Sig      []byte         `json:"signature"`
 	}
-	return json.Marshal(oip1Txn{s.From, s.To, s.Gas, s.GasPrice, s.Value, s.Nonce, s.Data, s.Time, s.Sig})
+	return t.Value.Add(fee)
 }
 
 func (s SignedTxn) IsAuthentic() (bool, error) {
@@ -139,7 +97,7 @@ func (s SignedTxn) IsAuthentic() (bool, error) {
 	return recoveredAccount.Hex() == s.From.Hex(), nil
 }
 
-func NewTxn(from, to common.Address, gas, gasPrice, value, nonce uint, data string) Txn {
+func NewTxn(from, to common.Address, gas uint, gasPrice, value Amount, nonce uint, data string) Txn {
 	return Txn{
 		from,
 		to,
@@ -149,11 +107,13 @@ func NewTxn(from, to common.Address, gas, gasPrice, value, nonce uint, data stri
 		nonce,
 		data,
 		uint64(time.Now().Unix()),
+		VersionLegacyJSON,
+		"",
 	}
 }
 
-func NewDefaultTxn(from, to common.Address, value, nonce uint, data string) Txn {
-	return NewTxn(from, to, TxnGas, DefaultGasPrice, value, nonce, data)
+func NewDefaultTxn(from, to common.Address, value Amount, nonce uint, data string) Txn {
+	return NewTxn(from, to, TxnGas, NewAmount(DefaultGasPrice), value, nonce, data)
 }
 
 func NewSignedTxn(txn Txn, sig []byte) SignedTxn {
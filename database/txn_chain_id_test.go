@@ -0,0 +1,65 @@
+package database
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestTxnChainID(t *testing.T) {
+	key, sender := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	genesis := Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB", ChainID: "mainnet"}
+	state := newTestState(t, genesis)
+	defer state.Close()
+
+	signFor := func(chainID string, nonce uint) SignedTxn {
+		txn := newTestTxn(sender, miner, NewAmount(10), nonce, "")
+		txn.ChainID = chainID
+		return signTestTxn(t, txn, key)
+	}
+
+	if state.TxnChainID() != "mainnet" {
+		t.Fatalf("expected the Txns to be signed for chain mainnet, got %q", state.TxnChainID())
+	}
+
+	pending := state.Copy()
+	if err := ApplyTxn(signFor("testnet", 1), &pending); err == nil || !strings.Contains(err.Error(), "signed for chain") {
+		t.Fatalf("a Txn signed for another chain must be rejected, got error: %v", err)
+	}
+	if err := ApplyTxn(signFor("", 1), &pending); err == nil || !strings.Contains(err.Error(), "signed for chain") {
+		t.Fatalf("a Txn signed without chain ID must be rejected after OIP-10, got error: %v", err)
+	}
+
+	// Changing the chain ID of a signed Txn changes its hash, the signature doesn't match anymore
+	replayed := signFor("testnet", 1)
+	replayed.ChainID = "mainnet"
+	if err := ApplyTxn(replayed, &pending); err == nil {
+		t.Fatal("a Txn with a forged chain ID must be rejected")
+	}
+
+	txn := signFor("mainnet", 1)
+	if err := ApplyTxn(txn, &pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner, txn)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Prior to OIP-10 the Txns are signed without chain ID
+	genesis.Forks = testForks(map[string]uint64{OIP10: ForkDisabled})
+	legacyState := newTestState(t, genesis)
+	defer legacyState.Close()
+
+	if legacyState.TxnChainID() != "" {
+		t.Fatalf("expected no chain ID prior to OIP-10, got %q", legacyState.TxnChainID())
+	}
+	pending = legacyState.Copy()
+	if err := ApplyTxn(signFor("mainnet", 1), &pending); err == nil || !strings.Contains(err.Error(), "OIP-10") {
+		t.Fatalf("a Txn with a chain ID must be rejected prior to OIP-10, got error: %v", err)
+	}
+	if err := ApplyTxn(signFor("", 1), &pending); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"sort"
+)
+
+// sortTxns returns a copy of the Txns in the order they're applied to the State prior to OIP-9, by time
+func sortTxns(txns []SignedTxn) []SignedTxn {
+	sorted := make([]SignedTxn, len(txns))
+	copy(sorted, txns)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+	return sorted
+}
+
+// mergeTxns returns the Txns in the canonical order of OIP-9. The Txns of each sender are
+// ordered by nonce, and the senders are merged by taking the next Txn with the highest gas
+// price, the one of the lowest sender address on equal prices. When accept rejects a Txn,
+// it's left out with the following Txns of its sender.
+func mergeTxns(txns []SignedTxn, accept func(SignedTxn) (bool, error)) ([]SignedTxn, error) {
+	bySender := make(map[common.Address][]SignedTxn)
+	for _, txn := range txns {
+		bySender[txn.From] = append(bySender[txn.From], txn)
+	}
+
+	senders := make([]common.Address, 0, len(bySender))
+	for sender, senderTxns := range bySender {
+		senders = append(senders, sender)
+		sort.Slice(senderTxns, func(i, j int) bool {
+			if senderTxns[i].Nonce != senderTxns[j].Nonce {
+				return senderTxns[i].Nonce < senderTxns[j].Nonce
+			}
+			// Txns reusing a nonce are invalid, they're still ordered the same way on every node
+			return bytes.Compare(senderTxns[i].Sig, senderTxns[j].Sig) < 0
+		})
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		return bytes.Compare(senders[i][:], senders[j][:]) < 0
+	})
+
+	merged := make([]SignedTxn, 0, len(txns))
+	for {
+		next := -1
+		for i, sender := range senders {
+			queue := bySender[sender]
+			if len(queue) == 0 {
+				continue
+			}
+			if next == -1 || queue[0].GasPrice.Cmp(bySender[senders[next]][0].GasPrice) > 0 {
+				next = i
+			}
+		}
+		if next == -1 {
+			return merged, nil
+		}
+
+		sender := senders[next]
+		txn := bySender[sender][0]
+		bySender[sender] = bySender[sender][1:]
+
+		if accept != nil {
+			ok, err := accept(txn)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				bySender[sender] = nil
+				continue
+			}
+		}
+		merged = append(merged, txn)
+	}
+}
+
+// validateTxnOrder checks the Txns of a block are in the canonical order of OIP-9
+func validateTxnOrder(txns []SignedTxn) error {
+	canonical, err := mergeTxns(txns, nil)
+	if err != nil {
+		return err
+	}
+	for i := range txns {
+		if !bytes.Equal(txns[i].Sig, canonical[i].Sig) || txns[i].Txn != canonical[i].Txn {
+			return fmt.Errorf("the Txns of the block must be ordered by sender nonce and gas price, Txn %d is out of order", i)
+		}
+	}
+	return nil
+}
+
+// SelectBlockTxns returns the Txns of the next block in the order they must be applied, as many
+// as its limits leave room for. The Txns left out wait for the following blocks.
+//
+// Prior to OIP-9 the Txns are ordered by time and the selection stops at the first Txn that doesn't
+// fit. Since OIP-9 they're merged in the canonical order, which can differ from the order they were
+// accepted in, and a Txn that doesn't fit or can't be applied anymore in that order is left out
+// with the following Txns of its sender.
+func (s *State) SelectBlockTxns(txns []SignedTxn) ([]SignedTxn, error) {
+	space, err := s.newBlockSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.Rules().IsOIP9 {
+		sorted := sortTxns(txns)
+		for i, txn := range sorted {
+			ok, txnSize, err := space.fits(txn)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return sorted[:i], nil
+			}
+			space.add(txn, txnSize)
+		}
+		return sorted, nil
+	}
+
+	pending := s.Copy()
+	return mergeTxns(txns, func(txn SignedTxn) (bool, error) {
+		ok, txnSize, err := space.fits(txn)
+		if err != nil || !ok {
+			return false, err
+		}
+		if err = applyTxn(txn, &pending, true); err != nil {
+			return false, nil
+		}
+		space.add(txn, txnSize)
+		return true, nil
+	})
+}
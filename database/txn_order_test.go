@@ -0,0 +1,117 @@
+package database
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalTxnOrder(t *testing.T) {
+	keyA, senderA := newTestAccount(t)
+	keyB, senderB := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	newTxn := func(key *ecdsa.PrivateKey, from common.Address, gasPrice uint64, nonce uint, time uint64) SignedTxn {
+		txn := newTestTxn(from, miner, NewAmount(10), nonce, "")
+		txn.GasPrice = NewAmount(gasPrice)
+		txn.Time = time
+		return signTestTxn(t, txn, key)
+	}
+	a1 := newTxn(keyA, senderA, 1, 1, 100)
+	a2 := newTxn(keyA, senderA, 5, 2, 101)
+	b1 := newTxn(keyB, senderB, 2, 1, 102)
+	b2 := newTxn(keyB, senderB, 2, 2, 103)
+
+	// B pays more for its next Txn until A2 can be taken
+	canonical, err := mergeTxns([]SignedTxn{a2, b2, a1, b1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SignedTxn{b1, b2, a1, a2}
+	for i := range expected {
+		if !bytes.Equal(canonical[i].Sig, expected[i].Sig) {
+			t.Fatalf("Txn %d out of the canonical order, expected nonce %d of %s, got nonce %d of %s", i, expected[i].Nonce, expected[i].From, canonical[i].Nonce, canonical[i].From)
+		}
+	}
+
+	// On equal gas prices the lowest sender address goes first
+	canonical, err = mergeTxns([]SignedTxn{newTxn(keyB, senderB, 1, 1, 104), a1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (bytes.Compare(senderA[:], senderB[:]) < 0) != (canonical[0].From == senderA) {
+		t.Fatalf("expected the Txn of the lowest sender address first, got %s", canonical[0].From)
+	}
+
+	genesis := Genesis{Balances: map[common.Address]Amount{senderA: NewAmount(1000), senderB: NewAmount(1000)}, Symbol: "OPB"}
+	state := newTestState(t, genesis)
+	defer state.Close()
+
+	_, err = state.AddBlock(mineTestBlock(t, state, miner, a1, b1, a2, b2))
+	if err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Fatalf("a block with Txns out of the canonical order must be rejected, got error: %v", err)
+	}
+
+	block := mineTestBlock(t, state, miner, expected...)
+	if _, err = state.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if !bytes.Equal(block.Txns[i+1].Sig, expected[i].Sig) {
+			t.Fatalf("the validation must not reorder the Txns of the block, Txn %d moved", i)
+		}
+	}
+
+	// Prior to OIP-9 the Txns are applied by time, whatever their order in the block
+	genesis.Forks = testForks(map[string]uint64{OIP9: ForkDisabled})
+	legacyState := newTestState(t, genesis)
+	defer legacyState.Close()
+
+	if _, err = legacyState.AddBlock(mineTestBlock(t, legacyState, miner, b2, a2, b1, a1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectBlockTxnsInCanonicalOrder(t *testing.T) {
+	key, sender := newTestAccount(t)
+	poorKey, poor := newTestAccount(t)
+	miner := NewAccount("0x486512fA9fbaF06568D13826afe7822842b9E685")
+
+	state := newTestState(t, Genesis{Balances: map[common.Address]Amount{sender: NewAmount(1000)}, Symbol: "OPB"})
+	defer state.Close()
+
+	// The pending Txns were accepted in this order, but the Txn of the poor account pays more
+	// and comes first in the canonical order, before it receives the funds it spends
+	funding := signTestTxn(t, newTestTxn(sender, poor, NewAmount(100), 1, ""), key)
+	spendingTxn := newTestTxn(poor, miner, NewAmount(10), 1, "")
+	spendingTxn.GasPrice = NewAmount(5)
+	spending := signTestTxn(t, spendingTxn, poorKey)
+	pending := state.Copy()
+	for _, txn := range []SignedTxn{funding, spending} {
+		if err := ApplyTxn(txn, &pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	selected, err := state.SelectBlockTxns([]SignedTxn{spending, funding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || selected[0].From != sender {
+		t.Fatalf("expected only the funding Txn to be selected, got %d Txns", len(selected))
+	}
+	if _, err = state.AddBlock(mineTestBlock(t, state, miner, selected...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Txn left out fits in the next block
+	selected, err = state.SelectBlockTxns([]SignedTxn{spending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = state.AddBlock(mineTestBlock(t, state, miner, selected...)); err != nil || len(selected) != 1 {
+		t.Fatalf("expected the spending Txn to be mined in the next block, got %d Txns: %v", len(selected), err)
+	}
+}
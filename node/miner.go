@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"kryptcoin/database"
@@ -11,16 +12,23 @@ import (
 	"time"
 )
 
+// outOfTurnSealDelay is how long a sealer out of its turn waits before sealing a block of a proof of authority chain
+const outOfTurnSealDelay = 2 * miningIntervalSeconds * time.Second
+
 type PendingBlock struct {
-	parent database.Hash
-	height uint64
-	time   uint64
-	miner  common.Address
-	txns   []database.SignedTxn
+	parent     database.Hash
+	height     uint64
+	time       uint64
+	miner      common.Address
+	txns       []database.SignedTxn
+	version    uint8
+	difficulty uint64
+	txRoot     database.Hash
+	stateRoot  database.Hash
 }
 
-func NewPendingBlock(parent database.Hash, height uint64, miner common.Address, txns []database.SignedTxn) PendingBlock {
-	return PendingBlock{parent, height, uint64(time.Now().Unix()), miner, txns}
+func NewPendingBlock(parent database.Hash, height uint64, time uint64, miner common.Address, txns []database.SignedTxn, version uint8, difficulty uint64, txRoot database.Hash, stateRoot database.Hash) PendingBlock {
+	return PendingBlock{parent, height, time, miner, txns, version, difficulty, txRoot, stateRoot}
 }
 
 func generateNonce() uint32 {
@@ -39,7 +47,7 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 	var hash database.Hash
 	var nonce uint32
 
-	for !database.IsBlockHashValid(hash) {
+	for attempts == 0 || !block.Header.IsHashValid(hash) {
 		select {
 		case <-ctx.Done():
 			log.Printf("Mining cancelled!")
@@ -56,6 +64,10 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 		}
 
 		block = database.NewBlock(pb.height, pb.parent, pb.time, nonce, pb.miner, pb.txns)
+		block.Header.Version = pb.version
+		block.Header.Difficulty = pb.difficulty
+		block.Header.TxRoot = pb.txRoot
+		block.Header.StateRoot = pb.stateRoot
 		blockHash, err := block.Hash()
 		if err != nil {
 			return database.Block{}, fmt.Errorf("counld not mine block: %s", err.Error())
@@ -66,6 +78,7 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 	log.Printf("\nMined new Block %x using PoW %s:\n", hash, fs.Unicode("\\U1F389"))
 	log.Printf("\tHeight: '%v'\n", block.Header.Height)
 	log.Printf("\tNonce: '%v'\n", block.Header.Nonce)
+	log.Printf("\tDifficulty: '%v'\n", block.Header.Difficulty)
 	log.Printf("\tCreated: '%v'\n", block.Header.Time)
 	log.Printf("\tMiner: '%v'\n", block.Header.Miner)
 	log.Printf("\tParent: '%v'\n\n", block.Header.Parent.Hex())
@@ -75,3 +88,40 @@ func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
 
 	return block, nil
 }
+
+// Seal signs the pending block of a proof of authority chain with the key of the sealer.
+// A sealer out of its turn waits first, so the in-turn sealer can propose its block.
+func Seal(ctx context.Context, pb PendingBlock, key *ecdsa.PrivateKey) (database.Block, error) {
+	if len(pb.txns) == 0 {
+		return database.Block{}, fmt.Errorf("mining empty blocks is not allowed")
+	}
+	if key == nil {
+		return database.Block{}, fmt.Errorf("sealing requires the key of the sealer %s", pb.miner)
+	}
+
+	if pb.difficulty != database.DifficultyInTurn {
+		log.Printf("Sealer %s is out of turn for block %d, waiting %s\n", pb.miner, pb.height, outOfTurnSealDelay)
+		select {
+		case <-ctx.Done():
+			log.Printf("Sealing cancelled!")
+			return database.Block{}, fmt.Errorf("sealing cancelled: %s", ctx.Err())
+		case <-time.After(outOfTurnSealDelay):
+		}
+	}
+
+	block := database.NewBlock(pb.height, pb.parent, pb.time, 0, pb.miner, pb.txns)
+	block.Header.Version = pb.version
+	block.Header.Difficulty = pb.difficulty
+	block.Header.TxRoot = pb.txRoot
+	block.Header.StateRoot = pb.stateRoot
+	block, err := database.SealBlock(block, key)
+	if err != nil {
+		return database.Block{}, fmt.Errorf("could not seal block: %s", err.Error())
+	}
+
+	log.Printf("\nSealed new Block at height %d:\n", block.Header.Height)
+	log.Printf("\tSealer: '%v'\n", block.Header.Miner)
+	log.Printf("\tDifficulty: '%v'\n", block.Header.Difficulty)
+	log.Printf("\tParent: '%v'\n\n", block.Header.Parent.Hex())
+	return block, nil
+}
@@ -11,6 +11,7 @@ import (
 	"kryptcoin/database"
 	"kryptcoin/wallet"
 	"testing"
+	"time"
 )
 
 func TestValidBlockHash(t *testing.T) {
@@ -19,9 +20,12 @@ func TestValidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	if !database.IsBlockHashValid(hash) {
+	if !database.IsBlockHashValid(hash, database.DifficultyTarget(database.MinDifficulty)) {
 		t.Fatalf("hash '%s' starting with 3 zeros is supposed to be valid", hexHash)
 	}
+	if !(database.BlockHeader{}).IsHashValid(hash) {
+		t.Fatalf("hash '%s' starting with 3 zeros is supposed to be valid prior to OIP-3", hexHash)
+	}
 }
 
 func TestInvalidBlockHash(t *testing.T) {
@@ -30,7 +34,15 @@ func TestInvalidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	if database.IsBlockHashValid(hash) {
+	// Prior to OIP-3 the hash must start with exactly 3 zeros
+	if (database.BlockHeader{}).IsHashValid(hash) {
+		t.Fatalf("hash '%s' is not supposed to be valid", hexHash)
+	}
+
+	hexHash = "0074f8fd89346fgc8"
+	hex.Decode(hash[:], []byte(hexHash))
+
+	if database.IsBlockHashValid(hash, database.DifficultyTarget(database.MinDifficulty)) {
 		t.Fatalf("hash '%s' is not supposed to be valid", hexHash)
 	}
 }
@@ -58,7 +70,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !database.IsBlockHashValid(minedBlockHash) {
+	if !minedBlock.Header.IsHashValid(minedBlockHash) {
 		t.Fatal("Invalid block hash produced.")
 	}
 
@@ -68,16 +80,26 @@ func TestMine(t *testing.T) {
 }
 
 func createRandomPendingBlock(privateKey *ecdsa.PrivateKey, miner common.Address) (PendingBlock, error) {
-	txn := database.NewDefaultTxn(miner, database.NewAccount(testKeystoreWhiteBeardAccount), 1, 1, "")
-	signedTxn, err := wallet.SignTxn(txn, privateKey)
+	txn := newTestTxn(testForks, miner, database.NewAccount(testKeystoreWhiteBeardAccount), database.NewAmount(1), 1, "")
+	signedTxn, err := wallet.SignTxn(txn, "", privateKey)
+	if err != nil {
+		return PendingBlock{}, err
+	}
+	txns := []database.SignedTxn{signedTxn}
+	txRoot, err := database.ComputeTxRoot(txns)
 	if err != nil {
 		return PendingBlock{}, err
 	}
 	return NewPendingBlock(
 		database.Hash{},
 		0,
+		uint64(time.Now().Unix()),
 		miner,
-		[]database.SignedTxn{signedTxn},
+		txns,
+		database.VersionRLP,
+		database.MinDifficulty,
+		txRoot,
+		database.Hash{},
 	), nil
 }
 
@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"kryptcoin/database"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -21,6 +23,7 @@ const (
 
 	pathNodeStatus = "/node/status"
 	pathNodeSync   = "/node/sync"
+	pathBlocks     = "/blocks/"
 
 	pathSyncQueryKeyFromBlock = "fromBlock"
 
@@ -41,8 +44,9 @@ type PeerNode struct {
 }
 
 type Node struct {
-	dataDir string
-	info    PeerNode
+	dataDir   string
+	info      PeerNode
+	stateOpts database.Options
 
 	state        *database.State // Main blockchain state after mined Txns have been applied
 	pendingState *database.State // temporary pending state to validate new incoming Txns, resets after block is mined
@@ -53,6 +57,11 @@ type Node struct {
 	newPendingTxns  chan database.SignedTxn
 	newSyncedBlocks chan database.Block
 	isMining        bool
+
+	// sealerKey signs the blocks of a proof of authority chain, nil when the node doesn't seal
+	sealerKey *ecdsa.PrivateKey
+	// minePendingBlock mines the pending blocks of a proof of work chain, Mine by default
+	minePendingBlock func(ctx context.Context, pb PendingBlock) (database.Block, error)
 }
 
 func (pn PeerNode) TcpAddress() string {
@@ -76,23 +85,35 @@ func (n *Node) IsKnownPeer(peer PeerNode) bool {
 }
 
 func NewNode(dataDir string, ip string, port uint64, bootstrap PeerNode, acct common.Address) *Node {
+	return NewNodeWithOptions(dataDir, ip, port, bootstrap, acct, database.DefaultOptions())
+}
+
+func NewNodeWithOptions(dataDir string, ip string, port uint64, bootstrap PeerNode, acct common.Address, stateOpts database.Options) *Node {
 	// Initialize a new map with only one known peer,
 	// the bootstrap node
 	knownPeers := make(map[string]PeerNode)
 	knownPeers[bootstrap.TcpAddress()] = bootstrap
 
 	return &Node{
-		dataDir:         dataDir,
-		info:            NewPeerNode(ip, port, false, acct, true),
-		knownPeers:      knownPeers,
-		pendingTxns:     make(map[string]database.SignedTxn),
-		archivedTxns:    make(map[string]database.SignedTxn),
-		newSyncedBlocks: make(chan database.Block),
-		newPendingTxns:  make(chan database.SignedTxn, 10000),
-		isMining:        false,
+		dataDir:          dataDir,
+		info:             NewPeerNode(ip, port, false, acct, true),
+		stateOpts:        stateOpts,
+		knownPeers:       knownPeers,
+		pendingTxns:      make(map[string]database.SignedTxn),
+		archivedTxns:     make(map[string]database.SignedTxn),
+		newSyncedBlocks:  make(chan database.Block),
+		newPendingTxns:   make(chan database.SignedTxn, 10000),
+		isMining:         false,
+		minePendingBlock: Mine,
 	}
 }
 
+// SetSealerKey sets the key the node seals the blocks of a proof of authority chain with,
+// the key of its miner account
+func (n *Node) SetSealerKey(key *ecdsa.PrivateKey) {
+	n.sealerKey = key
+}
+
 func NewPeerNode(ip string, port uint64, isBootstrap bool, acct common.Address, connected bool) PeerNode {
 	return PeerNode{ip, port, acct, isBootstrap, connected}
 }
@@ -100,7 +121,7 @@ func NewPeerNode(ip string, port uint64, isBootstrap bool, acct common.Address,
 func (n *Node) Run(ctx context.Context) error {
 	fmt.Printf("Listening on: %s:%d\n", n.info.IP, n.info.Port)
 
-	state, err := database.NewStateFromDisk(n.dataDir)
+	state, err := database.NewStateFromDiskWithOptions(n.dataDir, n.stateOpts)
 	if err != nil {
 		return err
 	}
@@ -127,6 +148,18 @@ func (n *Node) Run(ctx context.Context) error {
 		txnAddHandler(w, req, n)
 	})
 
+	handler.HandleFunc("/txn/", func(w http.ResponseWriter, req *http.Request) {
+		getTxnHandler(w, req, n)
+	})
+
+	handler.HandleFunc("/accounts/", func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/proof") {
+			getAccountProofHandler(w, req, n)
+			return
+		}
+		listAccountTxnsHandler(w, req, n)
+	})
+
 	handler.HandleFunc(pathNodeStatus, func(w http.ResponseWriter, req *http.Request) {
 		statusHandler(w, req, n)
 	})
@@ -139,7 +172,7 @@ func (n *Node) Run(ctx context.Context) error {
 		syncHandler(w, req, n)
 	})
 
-	handler.HandleFunc("/blocks/", func(w http.ResponseWriter, req *http.Request) {
+	handler.HandleFunc(pathBlocks, func(w http.ResponseWriter, req *http.Request) {
 		getBlockByHashOrHeightHandler(w, req, n)
 	})
 
@@ -147,6 +180,10 @@ func (n *Node) Run(ctx context.Context) error {
 		listMempoolTxnsHandler(w, req, n.pendingTxns)
 	})
 
+	handler.HandleFunc("/chain/supply", func(w http.ResponseWriter, req *http.Request) {
+		getSupplyHandler(w, req, n.state)
+	})
+
 	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port), Handler: handler}
 
 	go func() {
@@ -201,20 +238,61 @@ func (n *Node) mine(ctx context.Context) error {
 }
 
 func (n *Node) minePendingTxns(ctx context.Context) error {
+	// Nodes without a sealer key only relay the Txns of a proof of authority chain
+	if n.state.Consensus().Engine() == database.ConsensusPoA && n.sealerKey == nil {
+		return nil
+	}
+
+	// The block must come after the median time of the previous blocks, even if the local clock is behind
+	now := uint64(time.Now().Unix())
+	if minTime := n.state.MinNextBlockTime(); now < minTime {
+		now = minTime
+	}
+	// The Txns beyond the block limits stay pending for the next blocks
+	txns, err := n.state.SelectBlockTxns(n.getMineablePendingTxns(n.state.EncodingVersion(), n.state.TxnChainID()))
+	if err != nil {
+		return err
+	}
+	// The coinbase Txn alone doesn't make a block worth mining
+	if len(txns) == 0 {
+		return fmt.Errorf("mining empty blocks is not allowed")
+	}
+	txns, err = n.state.AddCoinbaseTxn(n.info.Account, txns)
+	if err != nil {
+		return err
+	}
+	txRoot, err := n.state.TxRoot(txns)
+	if err != nil {
+		return err
+	}
+	stateRoot, err := n.state.NextStateRoot(n.info.Account, txns)
+	if err != nil {
+		return err
+	}
 	blockToMine := NewPendingBlock(
 		n.state.LatestBlockHash(),
 		n.state.NextBlockHeight(),
+		now,
 		n.info.Account, // Potential block miner
-		n.getPendingTxnsAsArray(),
+		txns,
+		n.state.EncodingVersion(),
+		n.state.NextDifficulty(n.info.Account, now),
+		txRoot,
+		stateRoot,
 	)
 
-	minedBlock, err := Mine(ctx, blockToMine)
+	var minedBlock database.Block
+	if n.state.Consensus().Engine() == database.ConsensusPoA {
+		minedBlock, err = Seal(ctx, blockToMine, n.sealerKey)
+	} else {
+		minedBlock, err = n.minePendingBlock(ctx, blockToMine)
+	}
 	if err != nil {
 		return err
 	}
 	n.removeMinedPendingTxns(minedBlock)
 
-	err = n.addBlock(minedBlock)
+	_, err = n.addBlock(minedBlock)
 	if err != nil {
 		return err
 	}
@@ -248,6 +326,18 @@ func (n *Node) getPendingTxnsAsArray() []database.SignedTxn {
 	return txns
 }
 
+// getMineablePendingTxns returns the pending TXNs encoded with the given version and signed for the given chain ID,
+// TXNs signed before the encoding or chain ID forks can't be mined anymore after them.
+func (n *Node) getMineablePendingTxns(version uint8, chainID string) []database.SignedTxn {
+	txns := make([]database.SignedTxn, 0, len(n.pendingTxns))
+	for _, txn := range n.pendingTxns {
+		if txn.Version == version && txn.ChainID == chainID {
+			txns = append(txns, txn)
+		}
+	}
+	return txns
+}
+
 func (n *Node) AddPendingTxn(txn database.SignedTxn, peer PeerNode) error {
 	txnHash, err := txn.Hash()
 	if err != nil {
@@ -285,15 +375,72 @@ func (n *Node) syncPendingTxns(peer PeerNode, txns []database.SignedTxn) error {
 	return nil
 }
 
-func (n *Node) addBlock(block database.Block) error {
-	_, err := n.state.AddBlock(block)
+func (n *Node) addBlock(block database.Block) (database.ImportResult, error) {
+	result, err := n.state.ImportBlock(block)
 	if err != nil {
-		return err
+		return database.ImportResult{}, err
+	}
+
+	// The orphans connected after the block can change the main chain too
+	results := append([]database.ImportResult{result}, result.Orphans...)
+	changed := false
+	for _, r := range results {
+		if r.Status == database.BlockAppended || r.Status == database.BlockReorg {
+			changed = true
+		}
+	}
+	if !changed {
+		return result, nil
 	}
 
 	// Reset pending state
 	pendingState := n.state.Copy()
 	n.pendingState = &pendingState
 
-	return nil
+	for _, r := range results {
+		if r.Status != database.BlockReorg {
+			continue
+		}
+		for _, connected := range r.Connected {
+			n.removeMinedPendingTxns(connected)
+		}
+		n.restoreReorgedTxns(r)
+	}
+
+	return result, nil
+}
+
+// restoreReorgedTxns puts the TXNs of the blocks removed by a reorg back into the pending TXNs,
+// unless the new branch mined them too or they became invalid on top of it
+func (n *Node) restoreReorgedTxns(result database.ImportResult) {
+	mined := make(map[string]bool)
+	for _, block := range result.Connected {
+		for _, txn := range block.Txns {
+			txnHash, _ := txn.Hash()
+			mined[txnHash.Hex()] = true
+		}
+	}
+
+	for _, block := range result.Disconnected {
+		for _, txn := range block.Txns {
+			// The coinbase TXN only belongs to the replaced block
+			if txn.IsReward() {
+				continue
+			}
+			txnHash, err := txn.Hash()
+			if err != nil || mined[txnHash.Hex()] {
+				continue
+			}
+
+			delete(n.archivedTxns, txnHash.Hex())
+			err = database.ApplyTxn(txn, n.pendingState)
+			if err != nil {
+				log.Printf("Dropping TXN %s of a replaced block: %s\n", txnHash.Hex(), err)
+				continue
+			}
+
+			log.Printf("\t restoring pending TXN of a replaced block: %s\n", txnHash.Hex())
+			n.pendingTxns[txnHash.Hex()] = txn
+		}
+	}
 }
@@ -3,6 +3,7 @@ package node
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"io"
 	"kryptcoin/database"
@@ -24,6 +25,7 @@ const (
 	testKeystoreGoldRodgerFile    = "test_goldRodger--0418A658C5874D2Fe181145B685d2e73D761865D"
 	testKeystoreWhiteBeardFile    = "test_whiteBeard--486512fA9fbaF06568D13826afe7822842b9E685"
 	testKeystorePassword          = "goodbrain"
+	testChainID                   = "the-one-piece-berries-testnet"
 )
 
 func getTestDataDirPath() (string, error) {
@@ -51,7 +53,8 @@ func TestNode_Run(t *testing.T) {
 
 	// Define a context with timeout so the Node.Run() will
 	// only run for 5s
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 	err = n.Run(ctx)
 	if err != nil {
 		t.Fatal(err)
@@ -59,7 +62,7 @@ func TestNode_Run(t *testing.T) {
 }
 
 func TestNode_Mining(t *testing.T) {
-	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, 0)
+	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, testForks)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,9 +93,10 @@ func TestNode_Mining(t *testing.T) {
 	// is a blocking call
 	go func() {
 		time.Sleep(time.Second * 3)
-		txn := database.NewDefaultTxn(goldRodger, whiteBeard, 1, 1, "")
+		txn := newTestTxn(testForks, goldRodger, whiteBeard, database.NewAmount(1), 1, "")
 		signedTxn, err := wallet.SignWithKeystoreAccount(
 			txn,
+			testChainID,
 			goldRodger,
 			testKeystorePassword,
 			wallet.GetKeystoreDirPath(dataDir),
@@ -109,9 +113,10 @@ func TestNode_Mining(t *testing.T) {
 	// simulating that it came in while the first TXN is being mined
 	go func() {
 		time.Sleep(time.Second * 12)
-		txn := database.NewDefaultTxn(goldRodger, whiteBeard, 2, 2, "")
+		txn := newTestTxn(testForks, goldRodger, whiteBeard, database.NewAmount(2), 2, "")
 		signedTxn, err := wallet.SignWithKeystoreAccount(
 			txn,
+			testChainID,
 			goldRodger,
 			testKeystorePassword,
 			wallet.GetKeystoreDirPath(dataDir),
@@ -161,16 +166,16 @@ func TestNode_Mining(t *testing.T) {
 //	WhiteBeard succeeds and gets her block reward
 func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	conditions := []struct {
-		name     string
-		ForkOIP1 uint64
+		name  string
+		forks map[string]uint64
 	}{
-		{"Legacy", 10}, // Prior ForkOIP1 was activated at block 10
-		{"ForkOIP1", 0},
+		{"Legacy", testLegacyForks},
+		{"AllForks", testForks},
 	}
 
 	for _, cond := range conditions {
 		t.Run(cond.name, func(t *testing.T) {
-			dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, 0)
+			dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, cond.forks)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -187,12 +192,13 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 
 			n := NewNode(dataDir, pN.IP, pN.Port, pN, whiteBeard)
 
-			// Allow the mining to run for 10 minutes, worst case
-			ctx, shutDownNode := context.WithTimeout(context.Background(), time.Minute*10)
+			// Allow the mining to run for 2 minutes, worst case
+			ctx, shutDownNode := context.WithTimeout(context.Background(), time.Minute*2)
 
-			txn1 := database.NewDefaultTxn(goldRodger, whiteBeard, 1, 1, "")
+			txn1 := newTestTxn(cond.forks, goldRodger, whiteBeard, database.NewAmount(1), 1, "")
 			signedTxn1, err := wallet.SignWithKeystoreAccount(
 				txn1,
+				testTxnChainID(cond.forks),
 				goldRodger,
 				testKeystorePassword,
 				wallet.GetKeystoreDirPath(dataDir),
@@ -200,9 +206,10 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			txn2 := database.NewDefaultTxn(goldRodger, whiteBeard, 2, 2, "")
+			txn2 := newTestTxn(cond.forks, goldRodger, whiteBeard, database.NewAmount(2), 2, "")
 			signedTxn2, err := wallet.SignWithKeystoreAccount(
 				txn2,
+				testTxnChainID(cond.forks),
 				goldRodger,
 				testKeystorePassword,
 				wallet.GetKeystoreDirPath(dataDir),
@@ -210,7 +217,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			txn2Hash, err := txn2.Hash()
+			txn2Hash, err := signedTxn2.Hash()
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -218,18 +225,58 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 			// Pre-mine a valid block without running the `n.Run()`
 			// with gold_rodger as a miner who will receive the block reward,
 			// to simulate the block came on the fly from another peer
+			state, err := database.NewStateFromDisk(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			syncedTxns, err := state.AddCoinbaseTxn(goldRodger, []database.SignedTxn{signedTxn1})
+			if err != nil {
+				state.Close()
+				t.Fatal(err)
+			}
+			txRoot, err := state.TxRoot(syncedTxns)
+			if err != nil {
+				state.Close()
+				t.Fatal(err)
+			}
+			stateRoot, err := state.NextStateRoot(goldRodger, syncedTxns)
+			if err != nil {
+				state.Close()
+				t.Fatal(err)
+			}
+			now := uint64(time.Now().Unix())
 			validPreMinedPendingBlock := NewPendingBlock(
 				database.Hash{},
 				0,
+				now,
 				goldRodger,
-				[]database.SignedTxn{signedTxn1},
+				syncedTxns,
+				state.EncodingVersion(),
+				state.NextDifficulty(goldRodger, now),
+				txRoot,
+				stateRoot,
 			)
+			state.Close()
 
 			validSyncedBlock, err := Mine(ctx, validPreMinedPendingBlock)
 			if err != nil {
 				t.Fatal(err)
 			}
 
+			// The first block white_beard mines at the minimum difficulty would be found before the
+			// synced block arrives, its mining only ends once it's cancelled
+			miningStarted := make(chan struct{})
+			n.minePendingBlock = func(ctx context.Context, pb PendingBlock) (database.Block, error) {
+				select {
+				case <-miningStarted:
+					return Mine(ctx, pb)
+				default:
+				}
+				close(miningStarted)
+				<-ctx.Done()
+				return database.Block{}, fmt.Errorf("mining cancelled: %s", ctx.Err())
+			}
+
 			// Add 2 new TXNs into white_beard's node
 			go func() {
 				time.Sleep(time.Second * 8)
@@ -237,12 +284,14 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 				err := n.AddPendingTxn(signedTxn1, pN)
 				if err != nil {
 					t.Error(err)
+					shutDownNode()
 					return
 				}
 
 				err = n.AddPendingTxn(signedTxn2, pN)
 				if err != nil {
 					t.Error(err)
+					shutDownNode()
 					return
 				}
 			}()
@@ -250,15 +299,21 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 			// Once white_beard is mining the block, simulate
 			// that gold_rodger mines the block with TXN1 faster
 			go func() {
-				time.Sleep(time.Second * 12)
-				if n.isMining {
+				select {
+				case <-miningStarted:
+				case <-ctx.Done():
+					return
+				}
+				if !n.isMining {
 					t.Error("Node should be mining")
+					shutDownNode()
 					return
 				}
 
 				_, err := n.state.AddBlock(validSyncedBlock)
 				if err != nil {
 					t.Error(err)
+					shutDownNode()
 					return
 				}
 
@@ -268,26 +323,23 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 				time.Sleep(time.Second * 2)
 				if n.isMining {
 					t.Error("Synced block should have canceled the mining")
+					shutDownNode()
 					return
 				}
 
 				// Mined TXN1 by gold_rodger should be removed from the Mempool
 				_, onlyTxn2IsPending := n.pendingTxns[txn2Hash.Hex()]
-				if len(n.pendingTxns) != 1 && !onlyTxn2IsPending {
+				if len(n.pendingTxns) != 1 || !onlyTxn2IsPending {
 					t.Error("Synced block should have canceled the mining of already mined TXN1")
+					shutDownNode()
 					return
 				}
-
-				//time.Sleep(time.Second * (miningIntervalSeconds + 2))
-				//if !n.isMining {
-				//	t.Error("Should attempt to mine TXN2 npot included in the synced block")
-				//	return
-				//}
 			}()
 
 			go func() {
 				// Regularly check when both TXNs are mined
 				ticker := time.NewTicker(time.Second * 10)
+				defer ticker.Stop()
 
 				for {
 					select {
@@ -296,78 +348,76 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 							shutDownNode()
 							return
 						}
+					case <-ctx.Done():
+						return
 					}
 				}
 			}()
 
+			// Take a snapshot of the DB balances
+			// before the mining is finished and the 2 blocks
+			// are created.
+			startingBalances := make(chan [2]database.Amount, 1)
 			go func() {
 				time.Sleep(time.Second * 2)
-
-				// Take a snapshot of the DB balances
-				// before the mining is finished and the 2 blocks
-				// are created.
-				startingGoldRodgerBalance := n.state.Balances[goldRodger]
-				startingWhiteBeardBalance := n.state.Balances[whiteBeard]
-
-				// Wait until the 10min timeout is reached or
-				// the 2 blocks got already mined and the closeNode() was triggered
-				<-ctx.Done()
-
-				// Check balances again
-				endGoldRodgerBalance := n.state.Balances[goldRodger]
-				endWhiteBeardBalance := n.state.Balances[whiteBeard]
-
-				// In TXN1 gold_rodger transferred 1 OPB token to white_beard
-				// In TXN2 gold_rodger transferred 2 OPB token to white_beard
-				var expectedEndGoldRodgerBalance uint
-				var expectedEndWhiteBeardBalance uint
-
-				if n.state.IsForkOIP1() {
-					expectedEndGoldRodgerBalance = startingGoldRodgerBalance - txn1.TotalCost(true) - txn2.TotalCost(true) + database.Reward + txn1.GasCost()
-					expectedEndWhiteBeardBalance = startingWhiteBeardBalance + txn1.Value - txn2.Value + database.Reward + txn1.GasCost()
-				} else {
-					expectedEndGoldRodgerBalance = startingGoldRodgerBalance - txn1.TotalCost(false) - txn2.TotalCost(false) + database.Reward + database.TxnFee
-					expectedEndWhiteBeardBalance = startingWhiteBeardBalance + txn1.Value - txn2.Value + database.Reward + database.TxnFee
-				}
-
-				if endGoldRodgerBalance != expectedEndGoldRodgerBalance {
-					t.Errorf(
-						"gold_rodger's expected end balance is %d not %d",
-						expectedEndGoldRodgerBalance, endGoldRodgerBalance,
-					)
-					return
-				}
-
-				if endWhiteBeardBalance != expectedEndWhiteBeardBalance {
-					t.Errorf(
-						"white_beard's expected end balance is %d not %d",
-						expectedEndWhiteBeardBalance, endWhiteBeardBalance,
-					)
-					return
-				}
-
-				t.Logf("Starting gold_rodger balance: %d", startingGoldRodgerBalance)
-				t.Logf("Starting white_beard balance: %d", startingWhiteBeardBalance)
-				t.Logf("Ending gold_rodger balance: %d", endGoldRodgerBalance)
-				t.Logf("Ending white_beard balance: %d", endWhiteBeardBalance)
+				startingBalances <- [2]database.Amount{n.state.Balances[goldRodger], n.state.Balances[whiteBeard]}
 			}()
 
+			// Runs until the timeout is reached or
+			// the 2 blocks got already mined and the shutDownNode() was triggered
 			_ = n.Run(ctx)
+			shutDownNode()
+			if t.Failed() {
+				return
+			}
 
 			if n.state.LatestBlock().Header.Height != 1 {
-				t.Fatal("2 pending TXNs not mined into 2 valid blocks under 10min")
+				t.Fatal("2 pending TXNs not mined into 2 valid blocks under 2min")
 			}
 
 			if len(n.pendingTxns) != 0 {
 				t.Fatal("no pending TXNs should be left to mine")
 			}
+
+			// Check balances again
+			starting := <-startingBalances
+			endGoldRodgerBalance := n.state.Balances[goldRodger]
+			endWhiteBeardBalance := n.state.Balances[whiteBeard]
+
+			// In TXN1 gold_rodger transferred 1 OPB token to white_beard
+			// In TXN2 gold_rodger transferred 2 OPB token to white_beard
+			fee := uint(database.TxnFee)
+			if n.state.Rules().IsOIP1 {
+				fee = txn1.Gas * database.DefaultGasPrice
+			}
+
+			expectedEndGoldRodgerBalance, err := expectTestBalance(starting[0], database.Reward+fee, 1+fee+2+fee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedEndWhiteBeardBalance, err := expectTestBalance(starting[1], 1+2+database.Reward+fee, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if endGoldRodgerBalance != expectedEndGoldRodgerBalance {
+				t.Fatalf("gold_rodger's expected end balance is %s not %s", expectedEndGoldRodgerBalance, endGoldRodgerBalance)
+			}
+			if endWhiteBeardBalance != expectedEndWhiteBeardBalance {
+				t.Fatalf("white_beard's expected end balance is %s not %s", expectedEndWhiteBeardBalance, endWhiteBeardBalance)
+			}
+
+			t.Logf("Starting gold_rodger balance: %s", starting[0])
+			t.Logf("Starting white_beard balance: %s", starting[1])
+			t.Logf("Ending gold_rodger balance: %s", endGoldRodgerBalance)
+			t.Logf("Ending white_beard balance: %s", endWhiteBeardBalance)
 		})
 	}
 
 }
 
 func TestNode_ForgedTxn(t *testing.T) {
-	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, 0)
+	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, testForks)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -394,11 +444,12 @@ func TestNode_ForgedTxn(t *testing.T) {
 
 	amount := uint(5)
 	txnNonce := uint(1)
-	txn := database.NewDefaultTxn(goldRodger, whiteBeard, amount, txnNonce, "")
+	txn := newTestTxn(testForks, goldRodger, whiteBeard, database.NewAmount(uint64(amount)), txnNonce, "")
 
 	// Create a valid TXN sending 5 OPB tokens from gold_rodger to white_beard
 	validSignedTxn, err := wallet.SignWithKeystoreAccount(
 		txn,
+		testChainID,
 		goldRodger,
 		testKeystorePassword,
 		wallet.GetKeystoreDirPath(dataDir),
@@ -435,10 +486,11 @@ func TestNode_ForgedTxn(t *testing.T) {
 					if !forgedTxnAdded {
 						// Try to forge the same TXN but with a modified time
 						// Because the Txn.time changed, then the signature would be considered forged
-						forgedTxn := database.NewDefaultTxn(
+						forgedTxn := newTestTxn(
+							testForks,
 							goldRodger,
 							whiteBeard,
-							amount,
+							database.NewAmount(uint64(amount)),
 							txnNonce,
 							"",
 						)
@@ -460,13 +512,13 @@ func TestNode_ForgedTxn(t *testing.T) {
 		t.Fatal("should mine only one Txn since the second Txn was forged.")
 	}
 
-	if n.state.Balances[whiteBeard] != amount {
+	if n.state.Balances[whiteBeard] != database.NewAmount(uint64(amount)) {
 		t.Fatalf("forged Txn succeeded")
 	}
 }
 
 func TestNode_ReplayedTxn(t *testing.T) {
-	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, 0)
+	dataDir, goldRodger, whiteBeard, err := setupTestDir(10_000_000, testForks)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -499,11 +551,12 @@ func TestNode_ReplayedTxn(t *testing.T) {
 
 	amount := uint(5)
 	txnNonce := uint(1)
-	txn := database.NewDefaultTxn(goldRodger, whiteBeard, amount, txnNonce, "")
+	txn := newTestTxn(testForks, goldRodger, whiteBeard, database.NewAmount(uint64(amount)), txnNonce, "")
 
 	// Create a valid TXN sending 5 OPB tokens from gold_rodger to white_beard
 	validSignedTxn, err := wallet.SignWithKeystoreAccount(
 		txn,
+		testChainID,
 		goldRodger,
 		testKeystorePassword,
 		wallet.GetKeystoreDirPath(dataDir),
@@ -559,7 +612,7 @@ func TestNode_ReplayedTxn(t *testing.T) {
 
 	_ = n.Run(ctx)
 
-	if n.state.Balances[whiteBeard] == amount*2 {
+	if n.state.Balances[whiteBeard] == database.NewAmount(uint64(amount*2)) {
 		t.Fatalf("replayed attack was successful")
 	}
 
@@ -570,11 +623,11 @@ func TestNode_ReplayedTxn(t *testing.T) {
 
 func TestNode_SpamTransactions(t *testing.T) {
 	conditions := []struct {
-		name     string
-		ForkOIP1 uint64
+		name  string
+		forks map[string]uint64
 	}{
-		{"Legacy", 10}, // Prior ForkOIP1 was activated at block 10
-		{"ForkOIP1", 0},
+		{"Legacy", testLegacyForks},
+		{"AllForks", testForks},
 	}
 	for _, cond := range conditions {
 		t.Run(cond.name, func(t *testing.T) {
@@ -582,7 +635,7 @@ func TestNode_SpamTransactions(t *testing.T) {
 			whiteBeardBalance := uint(0)
 			minerBalance := uint(0)
 
-			dataDir, goldRodger, whiteBeard, err := setupTestDir(goldRodgerBalance, cond.ForkOIP1)
+			dataDir, goldRodger, whiteBeard, err := setupTestDir(goldRodgerBalance, cond.forks)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -622,16 +675,13 @@ func TestNode_SpamTransactions(t *testing.T) {
 
 				for i := uint(1); i <= count; i++ {
 					txnNonce := i
-					txn := database.NewDefaultTxn(goldRodger, whiteBeard, amount, txnNonce, "")
-					if cond.name == "Legacy" {
-						txn.Gas = 0
-						txn.GasPrice = 0
-					}
+					txn := newTestTxn(cond.forks, goldRodger, whiteBeard, database.NewAmount(uint64(amount)), txnNonce, "")
 					// Ensure every Txn has a unique timestamp and the nonce 0 is the oldest
 					txn.Time = now - uint64(count-i*100)
 
 					signedTxn, err := wallet.SignWithKeystoreAccount(
 						txn,
+						testTxnChainID(cond.forks),
 						goldRodger,
 						testKeystorePassword,
 						wallet.GetKeystoreDirPath(dataDir),
@@ -675,13 +725,13 @@ func TestNode_SpamTransactions(t *testing.T) {
 			var expectedWhiteBeardBalance uint
 			var expectedMinerBalance uint
 
-			if n.state.IsForkOIP1() {
+			if n.state.Rules().IsOIP1 {
 				expectedGoldRodgerBalance = goldRodgerBalance
 				expectedMinerBalance = minerBalance + database.Reward
 
 				for _, txn := range spamTxns {
-					expectedGoldRodgerBalance -= txn.TotalCost(true)
-					expectedMinerBalance += txn.GasCost()
+					expectedGoldRodgerBalance -= amount + txn.Gas*database.DefaultGasPrice
+					expectedMinerBalance += txn.Gas * database.DefaultGasPrice
 				}
 
 				expectedWhiteBeardBalance = whiteBeardBalance + (count * amount)
@@ -691,31 +741,31 @@ func TestNode_SpamTransactions(t *testing.T) {
 				expectedMinerBalance = minerBalance + database.Reward + (count * database.TxnFee)
 			}
 
-			if n.state.Balances[whiteBeard] != expectedWhiteBeardBalance {
+			if n.state.Balances[whiteBeard] != database.NewAmount(uint64(expectedWhiteBeardBalance)) {
 				t.Errorf(
-					"white_beard balance incorrect. Expected %d, got %d",
+					"white_beard balance incorrect. Expected %d, got %s",
 					expectedWhiteBeardBalance,
 					n.state.Balances[whiteBeard],
 				)
 			}
-			if n.state.Balances[goldRodger] != expectedGoldRodgerBalance {
+			if n.state.Balances[goldRodger] != database.NewAmount(uint64(expectedGoldRodgerBalance)) {
 				t.Errorf(
-					"gold_rodger balance incorrect. Expected %d, got %d",
+					"gold_rodger balance incorrect. Expected %d, got %s",
 					expectedGoldRodgerBalance,
 					n.state.Balances[goldRodger],
 				)
 			}
-			if n.state.Balances[miner] != expectedMinerBalance {
+			if n.state.Balances[miner] != database.NewAmount(uint64(expectedMinerBalance)) {
 				t.Errorf(
-					"miner balance incorrect. Expected %d, got %d",
+					"miner balance incorrect. Expected %d, got %s",
 					expectedMinerBalance,
 					n.state.Balances[miner],
 				)
 			}
 
-			t.Logf("gold_rodger final balance: %d OPB", n.state.Balances[goldRodger])
-			t.Logf("white_beard final balance: %d OPB", n.state.Balances[whiteBeard])
-			t.Logf("miner final balance: %d OPB", n.state.Balances[miner])
+			t.Logf("gold_rodger final balance: %s OPB", n.state.Balances[goldRodger])
+			t.Logf("white_beard final balance: %s OPB", n.state.Balances[whiteBeard])
+			t.Logf("miner final balance: %s OPB", n.state.Balances[miner])
 		})
 	}
 
@@ -768,8 +818,67 @@ func copyKeystoreFileToTestDataDirPath(dataDir string) error {
 	return nil
 }
 
+// testForks activate every OIP from height 0
+var testForks = map[string]uint64{
+	database.OIP1:  0,
+	database.OIP2:  0,
+	database.OIP3:  0,
+	database.OIP4:  0,
+	database.OIP5:  0,
+	database.OIP6:  0,
+	database.OIP8:  0,
+	database.OIP9:  0,
+	database.OIP10: 0,
+	database.OIP11: 0,
+}
+
+// testLegacyForks are the forks of the default genesis.json, OIP-1 activates at height 10 and the later OIPs are disabled
+var testLegacyForks = map[string]uint64{
+	database.OIP1:  10,
+	database.OIP2:  database.ForkDisabled,
+	database.OIP3:  database.ForkDisabled,
+	database.OIP4:  database.ForkDisabled,
+	database.OIP5:  database.ForkDisabled,
+	database.OIP6:  database.ForkDisabled,
+	database.OIP8:  database.ForkDisabled,
+	database.OIP9:  database.ForkDisabled,
+	database.OIP10: database.ForkDisabled,
+	database.OIP11: database.ForkDisabled,
+}
+
+// newTestTxn returns a default Txn in the encoding of the first blocks of a chain with the forks
+func newTestTxn(forks map[string]uint64, from, to common.Address, value database.Amount, nonce uint, data string) database.Txn {
+	txn := database.NewDefaultTxn(from, to, value, nonce, data)
+	// Prior to OIP-1 the Txns pay a fixed fee
+	if forks[database.OIP1] > 0 {
+		txn.Gas = 0
+		txn.GasPrice = database.Amount{}
+	}
+	if forks[database.OIP2] == 0 {
+		txn.Version = database.VersionRLP
+	}
+	return txn
+}
+
+// testTxnChainID returns the chain ID the Txns of the first blocks of a chain with the forks are signed for
+func testTxnChainID(forks map[string]uint64) string {
+	if forks[database.OIP10] == 0 {
+		return testChainID
+	}
+	return ""
+}
+
+// expectTestBalance returns the balance once credited and debited
+func expectTestBalance(balance database.Amount, credit, debit uint) (database.Amount, error) {
+	balance, err := balance.Add(database.NewAmount(uint64(credit)))
+	if err != nil {
+		return database.Amount{}, err
+	}
+	return balance.Sub(database.NewAmount(uint64(debit)))
+}
+
 // setupTestNodeDir creates a default testing node directory with 2 keystore accounts
-func setupTestDir(goldRodgerStartBalance uint, forkOIP1 uint64) (dataDir string, goldRodger, whiteBeard common.Address, err error) {
+func setupTestDir(goldRodgerStartBalance uint, forks map[string]uint64) (dataDir string, goldRodger, whiteBeard common.Address, err error) {
 	goldRodger = database.NewAccount(testKeystoreGoldRodgerAccount)
 	whiteBeard = database.NewAccount(testKeystoreWhiteBeardAccount)
 
@@ -778,9 +887,9 @@ func setupTestDir(goldRodgerStartBalance uint, forkOIP1 uint64) (dataDir string,
 		return "", common.Address{}, common.Address{}, err
 	}
 
-	genesisBalances := make(map[common.Address]uint)
-	genesisBalances[goldRodger] = goldRodgerStartBalance
-	genesis := database.Genesis{Balances: genesisBalances, ForkOIP1: forkOIP1}
+	genesisBalances := make(map[common.Address]database.Amount)
+	genesisBalances[goldRodger] = database.NewAmount(uint64(goldRodgerStartBalance))
+	genesis := database.Genesis{Balances: genesisBalances, ChainID: testChainID, Forks: forks}
 	genesisJson, err := json.Marshal(genesis)
 	if err != nil {
 		return "", common.Address{}, common.Address{}, err
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
 	"kryptcoin/database"
 	"kryptcoin/wallet"
 	"net/http"
@@ -16,20 +17,20 @@ type ErrorResponse struct {
 }
 
 type BalancesResponse struct {
-	Hash     database.Hash           `json:"block_hash"`
-	Balances map[common.Address]uint `json:"balances"`
+	Hash     database.Hash                      `json:"block_hash"`
+	Balances map[common.Address]database.Amount `json:"balances"`
 }
 
 type TxnAddReq struct {
 	From string `json:"from"`
 	To   string `json:"to"`
 
-	Gas      uint `json:"gas"`
-	GasPrice uint `json:"gasPrice"`
+	Gas      uint            `json:"gas"`
+	GasPrice database.Amount `json:"gasPrice"`
 
-	Password string `json:"password"`
-	Value    uint   `json:"value"`
-	Data     string `json:"data"`
+	Password string          `json:"password"`
+	Value    database.Amount `json:"value"`
+	Data     string          `json:"data"`
 }
 
 type TxnAddRes struct {
@@ -44,9 +45,32 @@ type StatusRes struct {
 	Hash       database.Hash       `json:"block_hash"`
 	Height     uint64              `json:"block_height"`
 	KnownPeers map[string]PeerNode `json:"known_peers"`
+	// TotalWork is the work accumulated by the chain of the peer, missing from older peers
+	TotalWork *uint256.Int `json:"total_work,omitempty"`
 
 	// Exchange pending TXNs as part of the periodic Sync() interval
 	PendingTxns []database.SignedTxn `json:"pending_txns"`
+	// Forks tells which OIPs apply to the next block
+	Forks []database.Fork `json:"forks"`
+}
+
+const (
+	TxnStatusPending = "pending"
+	TxnStatusMined   = "mined"
+)
+
+type TxnRes struct {
+	Status        string             `json:"status"`
+	Txn           database.SignedTxn `json:"txn"`
+	BlockHash     *database.Hash     `json:"block_hash,omitempty"`
+	Height        *uint64            `json:"block_height,omitempty"`
+	Index         *uint32            `json:"index,omitempty"`
+	Confirmations uint64             `json:"confirmations"`
+}
+
+type AccountTxnsRes struct {
+	Txns       []database.AccountTxn `json:"txns"`
+	NextCursor string                `json:"next_cursor"`
 }
 
 type SyncRes struct {
@@ -84,9 +108,12 @@ func txnAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		nonce,
 		req.Data,
 	)
+	txn.Version = node.state.EncodingVersion()
+
 	// Decrypt private key stored in keystore file and sign the txn
 	signedTxn, err := wallet.SignWithKeystoreAccount(
 		txn,
+		node.state.TxnChainID(),
 		fromAcct,
 		req.Password,
 		wallet.GetKeystoreDirPath(node.dataDir),
@@ -109,7 +136,9 @@ func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		Hash:        node.state.LatestBlockHash(),
 		Height:      node.state.LatestBlock().Header.Height,
 		KnownPeers:  node.knownPeers,
+		TotalWork:   node.state.TotalWork(),
 		PendingTxns: node.getPendingTxnsAsArray(),
+		Forks:       node.state.ChainConfig().Forks(node.state.NextBlockHeight()),
 	}
 	writeRes(w, res)
 }
@@ -142,7 +171,7 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	blocks, err := database.GetBlocksAfter(hash, node.dataDir)
+	blocks, err := node.state.GetBlocksAfter(hash)
 	if err != nil {
 		writeErrorRes(w, err)
 		return
@@ -170,7 +199,7 @@ func getBlockByHashOrHeightHandler(w http.ResponseWriter, r *http.Request, node
 		hash = part
 	}
 
-	block, err := database.GetBlockByHashOrHeight(node.state, height, hash, node.dataDir)
+	block, err := node.state.GetBlockByHashOrHeight(height, hash)
 	if err != nil {
 		writeErrorRes(w, errorRequiredParams)
 		return
@@ -179,6 +208,110 @@ func getBlockByHashOrHeightHandler(w http.ResponseWriter, r *http.Request, node
 	writeRes(w, block)
 }
 
+func getTxnHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	hash := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/txn/"))
+	if strings.HasSuffix(hash, "/proof") {
+		getTxnProofHandler(w, strings.TrimSuffix(hash, "/proof"), node)
+		return
+	}
+	if hash == "" {
+		writeErrorRes(w, fmt.Errorf("txn hash is required"))
+		return
+	}
+
+	if txn, isPending := node.pendingTxns[hash]; isPending {
+		writeRes(w, TxnRes{Status: TxnStatusPending, Txn: txn})
+		return
+	}
+
+	minedTxn, err := node.state.GetMinedTxn(hash)
+	if err != nil {
+		writeErrorRes(w, err)
+		return
+	}
+
+	// The index may be ahead of the chain while a reorg replaces the block of the Txn
+	tip := node.state.LatestBlock().Header.Height
+	if minedTxn.Height > tip {
+		writeErrorRes(w, fmt.Errorf("unknown Txn: %s", hash))
+		return
+	}
+
+	confirmations := tip - minedTxn.Height + 1
+	writeRes(w, TxnRes{TxnStatusMined, minedTxn.Txn, &minedTxn.BlockHash, &minedTxn.Height, &minedTxn.Index, confirmations})
+}
+
+func getTxnProofHandler(w http.ResponseWriter, hash string, node *Node) {
+	// /txn/<hash>/proof
+	if _, isPending := node.pendingTxns[hash]; isPending {
+		writeErrorRes(w, fmt.Errorf("txn %s is not mined yet", hash))
+		return
+	}
+
+	proof, err := node.state.GetTxnProof(hash)
+	if err != nil {
+		writeErrorRes(w, err)
+		return
+	}
+	writeRes(w, proof)
+}
+
+func getAccountProofHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	// /accounts/<addr>/proof
+	params := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(params) != 3 || params[2] != "proof" || !common.IsHexAddress(params[1]) {
+		writeErrorRes(w, fmt.Errorf("expected /accounts/<address>/proof"))
+		return
+	}
+
+	proof, err := node.state.GetAccountProof(database.NewAccount(params[1]))
+	if err != nil {
+		writeErrorRes(w, err)
+		return
+	}
+	writeRes(w, proof)
+}
+
+func listAccountTxnsHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	// /accounts/<addr>/txns
+	params := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(params) != 3 || params[2] != "txns" || !common.IsHexAddress(params[1]) {
+		writeErrorRes(w, fmt.Errorf("expected /accounts/<address>/txns"))
+		return
+	}
+
+	limit := 0
+	if r.URL.Query().Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			writeErrorRes(w, fmt.Errorf("invalid limit: %s", r.URL.Query().Get("limit")))
+			return
+		}
+	}
+
+	txns, nextCursor, err := node.state.GetAccountTxns(
+		database.NewAccount(params[1]),
+		r.URL.Query().Get("cursor"),
+		limit,
+		r.URL.Query().Get("direction"),
+	)
+	if err != nil {
+		writeErrorRes(w, err)
+		return
+	}
+	writeRes(w, AccountTxnsRes{txns, nextCursor})
+}
+
 func listMempoolTxnsHandler(w http.ResponseWriter, r *http.Request, txns map[string]database.SignedTxn) {
 	writeRes(w, txns)
 }
+
+func getSupplyHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	supply, err := state.Supply()
+	if err != nil {
+		writeErrorRes(w, err)
+		return
+	}
+	writeRes(w, supply)
+}
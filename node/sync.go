@@ -30,39 +30,141 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 		return nil
 	}
 
-	// Ignore if peer has fewer blocks than local
-	if status.Height < localBlockHeight {
+	// Ignore if the peer's tip is already part of the local chain or a known side branch
+	if n.state.HasBlock(status.Hash) {
 		return nil
 	}
 
-	// Ignore if it's the genesis block and it has already been synced
-	if status.Height == 0 && !n.state.LatestBlockHash().IsEmpty() {
+	// Ignore if the peer's chain doesn't carry more work than the local one, a longer chain can carry less.
+	// Older peers don't report their work, those with fewer blocks than local are ignored.
+	if status.TotalWork != nil {
+		if status.TotalWork.Cmp(n.state.TotalWork()) <= 0 {
+			return nil
+		}
+	} else if status.Height < localBlockHeight {
 		return nil
 	}
 
-	newBlockCount := status.Height - localBlockHeight
-	if localBlockHeight == 0 && status.Height == 0 {
-		newBlockCount = 1
+	blocks, err := n.fetchBranchFromPeer(peer)
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
-	fmt.Printf("Found %d new block(s) from Peer %s\n", newBlockCount, peer.TcpAddress())
+	fmt.Printf("Found %d new block(s) from Peer %s\n", len(blocks), peer.TcpAddress())
 
-	blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
+	// A branch contradicting a checkpoint is fake, however long, none of its blocks is applied
+	for _, block := range blocks {
+		blockHash, err := block.Hash()
+		if err != nil {
+			return err
+		}
+		err = n.state.ChainConfig().ValidateCheckpoint(block.Header.Height, blockHash)
+		if err != nil {
+			return fmt.Errorf("peer %s serves a branch contradicting the checkpoints: %s", peer.TcpAddress(), err)
+		}
+	}
+	// Only the blocks leading to the latest checkpoint block are applied without recovering their Txn signatures
+	err = n.state.TrustCheckpointedBranch(blocks)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 
 	for _, block := range blocks {
-		err = n.addBlock(block)
+		result, err := n.addBlock(block)
 		if err != nil {
 			return err
 		}
-		n.newSyncedBlocks <- block
+		n.notifySyncedBlocks(result)
+
+		if result.Status == database.BlockOrphan {
+			err = n.fetchMissingAncestors(peer, block)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// notifySyncedBlocks notifies the miner of the new tips of the main chain, the block and the orphans connected after it
+func (n *Node) notifySyncedBlocks(result database.ImportResult) {
+	for _, r := range append([]database.ImportResult{result}, result.Orphans...) {
+		if r.Status == database.BlockAppended || r.Status == database.BlockReorg {
+			n.newSyncedBlocks <- r.Connected[len(r.Connected)-1]
+		}
+	}
+}
+
+// fetchMissingAncestors requests the ancestors of the orphan block from the peer that sent it, one at
+// a time, until one of them connects to a known block and the orphans waiting for it are connected too
+func (n *Node) fetchMissingAncestors(peer PeerNode, orphan database.Block) error {
+	missing := orphan.Header.Parent
+	for i := 0; i < database.MaxReorgDepth; i++ {
+		fmt.Printf("Requesting missing block %s from Peer %s\n", missing.Hex(), peer.TcpAddress())
+		block, err := fetchBlockFromPeer(peer, missing)
+		if err != nil {
+			return err
+		}
+
+		result, err := n.addBlock(block)
+		if err != nil {
+			return err
+		}
+		n.notifySyncedBlocks(result)
+
+		if result.Status != database.BlockOrphan {
+			return nil
+		}
+		missing = block.Header.Parent
+	}
+	return fmt.Errorf("orphan block at height %d of peer %s is missing more than %d ancestors", orphan.Header.Height, peer.TcpAddress(), database.MaxReorgDepth)
+}
+
+// fetchBranchFromPeer fetches the blocks of the peer after the newest local block the peer knows.
+// When the chain of the peer forked from the local chain, the local chain is searched backwards
+// for the common ancestor, one block at a time at first and then with doubling steps.
+func (n *Node) fetchBranchFromPeer(peer PeerNode) ([]database.Block, error) {
+	if !n.state.LatestBlockHash().IsEmpty() {
+		tip := n.state.LatestBlock().Header.Height
+		step := uint64(1)
+		for height := tip; height+database.MaxReorgDepth > tip; {
+			block, err := n.state.GetBlockByHashOrHeight(height, "")
+			if err != nil {
+				return nil, err
+			}
+
+			blocks, err := fetchBlocksFromPeer(peer, block.Key)
+			if err != nil {
+				return nil, err
+			}
+			// The peer only returns blocks after a block of its chain
+			if len(blocks) > 0 {
+				return blocks, nil
+			}
+
+			if height == 0 {
+				break
+			}
+			if height < step {
+				height = 0
+			} else {
+				height -= step
+			}
+			if tip-height >= 2 {
+				step *= 2
+			}
+		}
+
+		if tip >= database.MaxReorgDepth {
+			return nil, fmt.Errorf("peer %s forked more than %d blocks deep", peer.TcpAddress(), database.MaxReorgDepth)
+		}
+	}
+
+	// No common block, the chains differ from the first block
+	return fetchBlocksFromPeer(peer, database.Hash{})
+}
+
 func (n *Node) syncKnownPeers(status StatusRes) error {
 	for _, statusPeer := range status.KnownPeers {
 		if !n.IsKnownPeer(statusPeer) {
@@ -169,6 +271,39 @@ func queryPeerStatus(peer PeerNode) (StatusRes, error) {
 	return statusRes, nil
 }
 
+// fetchBlockFromPeer fetches the block with the hash from the main chain of the peer
+func fetchBlockFromPeer(peer PeerNode, hash database.Hash) (database.Block, error) {
+	url := fmt.Sprintf("http://%s%s%s", peer.TcpAddress(), pathBlocks, hash.Hex())
+	res, err := http.Get(url)
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		errRes := ErrorResponse{}
+		err = readRes(res, &errRes)
+		if err != nil {
+			return database.Block{}, err
+		}
+		return database.Block{}, fmt.Errorf("peer %s can't send block %s: %s", peer.TcpAddress(), hash.Hex(), errRes.Error)
+	}
+
+	blockFs := database.BlockFS{}
+	err = readRes(res, &blockFs)
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	blockHash, err := blockFs.Value.Hash()
+	if err != nil {
+		return database.Block{}, err
+	}
+	if blockHash != hash {
+		return database.Block{}, fmt.Errorf("peer %s sent block %s instead of %s", peer.TcpAddress(), blockHash.Hex(), hash.Hex())
+	}
+	return blockFs.Value, nil
+}
+
 func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Block, error) {
 	fmt.Printf("Importing blocks from Peer %s...\n", peer.TcpAddress())
 
@@ -185,6 +320,16 @@ func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Blo
 		return nil, err
 	}
 
+	// A pruned peer can't serve the blocks before its oldest block
+	if res.StatusCode != http.StatusOK {
+		errRes := ErrorResponse{}
+		err = readRes(res, &errRes)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("peer %s can't sync blocks: %s", peer.TcpAddress(), errRes.Error)
+	}
+
 	syncRes := SyncRes{}
 	err = readRes(res, &syncRes)
 	if err != nil {
@@ -26,7 +26,9 @@ func Sign(msg []byte, privateKey *ecdsa.PrivateKey) (sig []byte, err error) {
 	return crypto.Sign(msgHash[:], privateKey)
 }
 
-func SignTxn(txn database.Txn, privateKey *ecdsa.PrivateKey) (database.SignedTxn, error) {
+// SignTxn signs the Txn for the chain with the ID, see State.TxnChainID
+func SignTxn(txn database.Txn, chainID string, privateKey *ecdsa.PrivateKey) (database.SignedTxn, error) {
+	txn.ChainID = chainID
 	rawTxn, err := txn.Encode()
 	if err != nil {
 		return database.SignedTxn{}, err
@@ -49,27 +51,36 @@ func Verify(msg, sig []byte) (*ecdsa.PublicKey, error) {
 	return recoveredPublicKey, nil
 }
 
-func SignWithKeystoreAccount(txn database.Txn, acct common.Address, password, keystoreDir string) (database.SignedTxn, error) {
+func SignWithKeystoreAccount(txn database.Txn, chainID string, acct common.Address, password, keystoreDir string) (database.SignedTxn, error) {
+	privateKey, err := LoadKeystoreKey(acct, password, keystoreDir)
+	if err != nil {
+		return database.SignedTxn{}, err
+	}
+	signedTxn, err := SignTxn(txn, chainID, privateKey)
+	if err != nil {
+		return database.SignedTxn{}, err
+	}
+	return signedTxn, nil
+}
+
+// LoadKeystoreKey decrypts the private key of the keystore account
+func LoadKeystoreKey(acct common.Address, password, keystoreDir string) (*ecdsa.PrivateKey, error) {
 	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
 	ksAccount, err := ks.Find(accounts.Account{Address: acct})
 	if err != nil {
-		return database.SignedTxn{}, err
+		return nil, err
 	}
 
 	ksAccountJson, err := os.ReadFile(ksAccount.URL.Path)
 	if err != nil {
-		return database.SignedTxn{}, err
+		return nil, err
 	}
 
 	key, err := keystore.DecryptKey(ksAccountJson, password)
 	if err != nil {
-		return database.SignedTxn{}, err
-	}
-	signedTxn, err := SignTxn(txn, key.PrivateKey)
-	if err != nil {
-		return database.SignedTxn{}, err
+		return nil, err
 	}
-	return signedTxn, nil
+	return key.PrivateKey, nil
 }
 
 func NewRandomKey() (*keystore.Key, error) {